@@ -0,0 +1,108 @@
+package core
+
+import (
+	"bytes"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+var (
+	// accessLogTemplate is the compiled access record template
+	accessLogTemplate *template.Template
+
+	// accessLogFields specifies whether to attach access record fields to each access log entry (i.e. for structured output)
+	accessLogFields bool
+)
+
+// AccessRecord holds the details of a single finished request, as made available to the access log template
+type AccessRecord struct {
+	RequestID   string
+	Time        string // in common log format
+	Timestamp   time.Time
+	IP          string
+	Port        string
+	Selector    string
+	Query       string
+	Outcome     string // 'served' or ErrorCode
+	Bytes       int64
+	Duration    time.Duration
+	DurationMs  float64
+	CacheHit    bool
+	CGIExitCode string // '-' if not CGI
+}
+
+// compileAccessLogTemplate parses supplied access log template string
+func compileAccessLogTemplate(format string) *template.Template {
+	tmpl, err := template.New("access").Parse(format)
+	if err != nil {
+		SystemLog.Fatal(accessLogTemplateErrStr, err.Error())
+	}
+	return tmpl
+}
+
+// newAccessRecord returns a new AccessRecord for a finished client request, with supplied final Error status
+func newAccessRecord(client *Client, err Error) *AccessRecord {
+	now := time.Now()
+	record := &AccessRecord{
+		RequestID:   client.ID(),
+		Time:        now.Format("02/Jan/2006:15:04:05 -0700"),
+		Timestamp:   now,
+		IP:          client.IP(),
+		Port:        client.Port(),
+		Selector:    "-",
+		Outcome:     "served",
+		Bytes:       client.Conn().Written(),
+		Duration:    client.Duration(),
+		DurationMs:  float64(client.Duration().Microseconds()) / 1000,
+		CacheHit:    client.cacheHit,
+		CGIExitCode: "-",
+	}
+
+	if request := client.Request(); request != nil {
+		record.Selector = request.Path().Selector()
+		record.Query = request.Params()
+	}
+	if err != nil {
+		record.Outcome = strconv.Itoa(int(err.Code()))
+	}
+	if client.cgiExitCode >= 0 {
+		record.CGIExitCode = strconv.Itoa(client.cgiExitCode)
+	}
+
+	return record
+}
+
+// fields returns the access record as key/value log fields
+func (r *AccessRecord) fields() []interface{} {
+	return []interface{}{
+		"request_id", r.RequestID,
+		"ip", r.IP,
+		"port", r.Port,
+		"selector", r.Selector,
+		"query", r.Query,
+		"outcome", r.Outcome,
+		"bytes", r.Bytes,
+		"duration_ms", r.DurationMs,
+		"cache_hit", r.CacheHit,
+		"cgi_exit_code", r.CGIExitCode,
+	}
+}
+
+// logAccess writes a single access record for a finished client request to the access log
+func logAccess(client *Client, err Error) {
+	record := newAccessRecord(client, err)
+
+	// Render the record using template
+	buf := &bytes.Buffer{}
+	if tmplErr := accessLogTemplate.Execute(buf, record); tmplErr != nil {
+		SystemLog.Error(accessLogTemplateErrStr, tmplErr.Error())
+		return
+	}
+
+	if accessLogFields {
+		AccessLog.With(record.fields()...).Info("%s", buf.String())
+	} else {
+		AccessLog.Info("%s", buf.String())
+	}
+}
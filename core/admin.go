@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// adminListener holds the global admin socket listener (nil if disabled)
+	adminListener *net.UnixListener
+
+	// adminCommands maps top-level admin command names to their handlers
+	adminCommands map[string]adminCommand
+)
+
+// adminCommand handles the arguments of an admin command, writing response lines to supplied writer
+type adminCommand func(w io.Writer, args []string) Error
+
+// newAdminListener removes any stale socket at path, then returns a new admin Unix socket listener or Error
+func newAdminListener(path string) (*net.UnixListener, Error) {
+	// Remove stale socket from previous run, ignore error (e.g. not exist)
+	os.Remove(path)
+
+	laddr, err := net.ResolveUnixAddr("unix", path)
+	if err != nil {
+		return nil, WrapError(AdminListenErr, err)
+	}
+
+	l, err := net.ListenUnix("unix", laddr)
+	if err != nil {
+		return nil, WrapError(AdminListenErr, err)
+	}
+
+	// Only owner should ever be able to talk to the admin socket
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		l.Close()
+		return nil, WrapError(AdminListenErr, err)
+	}
+
+	return l, nil
+}
+
+// setupAdminCommands sets the global admin command map
+func setupAdminCommands() {
+	adminCommands = map[string]adminCommand{
+		"help":   adminHelp,
+		"config": adminConfig,
+		"cache":  adminCache,
+		"conns":  adminConns,
+		"log":    adminLog,
+		"reload": adminReload,
+		"drain":  adminDrain,
+	}
+}
+
+// serveAdmin accepts admin connections until the listener is closed
+func serveAdmin() {
+	for {
+		conn, err := adminListener.AcceptUnix()
+		if err != nil {
+			SystemLog.Error(adminAcceptErrStr, err.Error())
+			return
+		}
+		go handleAdminConn(conn)
+	}
+}
+
+// handleAdminConn reads and handles admin commands line-by-line until EOF or 'quit'. Each response
+// is zero or more lines, terminated by an 'OK' or 'ERR <message>' line
+func handleAdminConn(conn *net.UnixConn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	writer := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		// Split line into command and arguments, skip empty
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		} else if fields[0] == "quit" {
+			return
+		}
+
+		// Look for command, run if found
+		var err Error
+		command, ok := adminCommands[fields[0]]
+		if ok {
+			err = command(writer, fields[1:])
+		} else {
+			err = NewError(InvalidAdminCommandErr)
+		}
+
+		// Write response terminator
+		if err != nil {
+			fmt.Fprintf(writer, "ERR %s\n", err.Error())
+		} else {
+			fmt.Fprint(writer, "OK\n")
+		}
+		if writer.Flush() != nil {
+			return
+		}
+	}
+}
+
+// adminHelp writes the list of supported admin commands
+func adminHelp(w io.Writer, args []string) Error {
+	fmt.Fprint(w, adminHelpStr)
+	return nil
+}
+
+// adminConfig writes the current value of every configuration flag
+func adminConfig(w io.Writer, args []string) Error {
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(w, "%s %q\n", f.Name, f.Value.String())
+	})
+	return nil
+}
+
+// adminConns writes a line for each active connection, with request ID, IP, port, selector and duration
+func adminConns(w io.Writer, args []string) Error {
+	for _, client := range ActiveClients() {
+		selector := client.Selector()
+		if selector == "" {
+			selector = "-"
+		}
+		fmt.Fprintf(w, "%s %s %s %s %s\n", client.ID(), client.IP(), client.Port(), selector, client.Duration().Round(time.Millisecond))
+	}
+	return nil
+}
+
+// adminLog writes the current log levels, or sets the level of the 'sys' or 'acc' log
+func adminLog(w io.Writer, args []string) Error {
+	switch len(args) {
+	case 0:
+		fmt.Fprintf(w, "sys %s\n", SystemLog.Level())
+		fmt.Fprintf(w, "acc %s\n", AccessLog.Level())
+		return nil
+
+	case 2:
+		level, ok := parseLogLevel(args[1])
+		if !ok {
+			return NewError(InvalidAdminArgsErr)
+		}
+
+		switch args[0] {
+		case "sys":
+			SystemLog.SetLevel(level)
+		case "acc":
+			AccessLog.SetLevel(level)
+		default:
+			return NewError(InvalidAdminArgsErr)
+		}
+
+		SystemLog.Info(adminLogLevelSetStr, args[0], level)
+		return nil
+
+	default:
+		return NewError(InvalidAdminArgsErr)
+	}
+}
+
+// adminReload marks all cached files for reload from disk, and reopens log files
+func adminReload(w io.Writer, args []string) Error {
+	FileSystem.Reload()
+	reopenLogFiles()
+	SystemLog.Info(adminReloadStr)
+	return nil
+}
+
+// adminDrain begins draining the server, with optional timeout (default shutdown timeout). Terminates once drained
+func adminDrain(w io.Writer, args []string) Error {
+	timeout := shutdownTimeout
+	if len(args) > 0 {
+		var err error
+		timeout, err = time.ParseDuration(args[0])
+		if err != nil {
+			return WrapError(InvalidAdminArgsErr, err)
+		}
+	}
+
+	fmt.Fprintf(w, "draining %d\n", len(ActiveClients()))
+	go Drain(timeout)
+	return nil
+}
+
+// adminCache handles the cache stats, keys and purge subcommands
+func adminCache(w io.Writer, args []string) Error {
+	if len(args) < 1 {
+		return NewError(InvalidAdminArgsErr)
+	}
+
+	switch args[0] {
+	case "stats":
+		stats := FileSystem.Stats()
+		fmt.Fprintf(w, "entries %d\n", stats.Entries)
+		fmt.Fprintf(w, "bytes %d\n", stats.Bytes)
+		fmt.Fprintf(w, "hits %d\n", stats.Hits)
+		fmt.Fprintf(w, "misses %d\n", stats.Misses)
+		fmt.Fprintf(w, "evictions %d\n", stats.Evictions)
+		fmt.Fprintf(w, "reloads %d\n", stats.Reloads)
+		return nil
+
+	case "keys":
+		for _, key := range FileSystem.Keys() {
+			fmt.Fprintln(w, key)
+		}
+		return nil
+
+	case "purge":
+		if len(args) != 2 {
+			return NewError(InvalidAdminArgsErr)
+		}
+		count := FileSystem.Purge(args[1])
+		SystemLog.Info(adminCachePurgedStr, count, args[1])
+		fmt.Fprintf(w, "purged %d\n", count)
+		return nil
+
+	case "purge-regex":
+		if len(args) != 2 {
+			return NewError(InvalidAdminArgsErr)
+		}
+		regex, err := regexp.Compile(args[1])
+		if err != nil {
+			return WrapError(InvalidAdminArgsErr, err)
+		}
+		count := FileSystem.PurgeRegex(regex)
+		SystemLog.Info(adminCachePurgedStr, count, args[1])
+		fmt.Fprintf(w, "purged %d\n", count)
+		return nil
+
+	default:
+		return NewError(InvalidAdminArgsErr)
+	}
+}
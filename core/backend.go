@@ -0,0 +1,154 @@
+package core
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backendSeparatorStr specifies the separator string to recognise in backend statements
+const backendSeparatorStr = " -> "
+
+// cgiBackends is the global slice of CGI backends, sorted by longest prefix first
+var cgiBackends []*cgiBackend
+
+// backendProtocol sends a request with supplied CGI environment over conn, writing response output to stdout and
+// error output to stderr. Returns the application exit status
+type backendProtocol func(conn net.Conn, env []string, stdout, stderr io.Writer) (int, error)
+
+// cgiBackend is a long-running CGI application listening on a socket, serving requests under a selector prefix
+type cgiBackend struct {
+	prefix   string
+	network  string
+	address  string
+	protocol backendProtocol
+}
+
+// String returns the backend target as network:address
+func (b *cgiBackend) String() string {
+	return b.network + ":" + b.address
+}
+
+// compileBackends turns a string of backend statements into a slice of cgiBackends using supplied protocol
+func compileBackends(backends string, protocol backendProtocol) []*cgiBackend {
+	compiled := make([]*cgiBackend, 0)
+
+	// Split backends string by new lines
+	for _, expr := range strings.Split(backends, "\n") {
+		// Skip empty expressions
+		if len(expr) == 0 {
+			continue
+		}
+
+		// Split into prefix and network:address target
+		split := strings.Split(expr, backendSeparatorStr)
+		if len(split) != 2 {
+			SystemLog.Fatal(backendInvalidStr, expr)
+		}
+		network, address := splitBy(split[1], ":")
+		if (network != "unix" && network != "tcp") || address == "" {
+			SystemLog.Fatal(backendInvalidStr, expr)
+		}
+
+		// Append cgiBackend and log
+		prefix := formatSelector(strings.TrimSuffix(split[0], "/"))
+		compiled = append(compiled, &cgiBackend{prefix, network, address, protocol})
+		SystemLog.Info(backendCompiledStr, expr)
+	}
+
+	return compiled
+}
+
+// addBackends adds backends to the global slice, keeping sorted by longest prefix first
+func addBackends(backends []*cgiBackend) {
+	cgiBackends = append(cgiBackends, backends...)
+	sort.SliceStable(cgiBackends, func(i, j int) bool {
+		return len(cgiBackends[i].prefix) > len(cgiBackends[j].prefix)
+	})
+}
+
+// matchBackend returns the backend serving a Path's selector, and success
+func matchBackend(p *Path) (*cgiBackend, bool) {
+	sel := p.Selector()
+	for _, backend := range cgiBackends {
+		if backend.prefix == "/" || sel == backend.prefix || strings.HasPrefix(sel, backend.prefix+"/") {
+			return backend, true
+		}
+	}
+	return nil, false
+}
+
+// serve serves a client request using the backend, the remaining selector after the prefix being passed as path info.
+// Output is passed through HTTP header stripping, and limited by the same run time and output size as CGI scripts
+func (b *cgiBackend) serve(client *Client, request *Request) Error {
+	// Request is for the backend 'script' at prefix, followed by path info
+	if b.prefix != "/" {
+		request.pathInfo = strings.TrimPrefix(request.Path().Selector(), b.prefix)
+		request.Path().Remap(b.prefix)
+	} else {
+		request.pathInfo = request.Path().Selector()
+	}
+	env := generateCGIEnv(client, request)
+
+	// Serve cached output if available
+	if err, ok := serveCachedCGIOutput(client, request); ok {
+		return err
+	}
+
+	// Connect to backend
+	conn, err := net.DialTimeout(b.network, b.address, maxCGIRunTime)
+	if err != nil {
+		return WrapError(CGIBackendErr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(maxCGIRunTime))
+
+	// Setup output writer capturing for caching, capping output size if enabled
+	capture := newCGICapture(request, client.Conn().Writer(), nil)
+	httpWriter := newhttpStripWriter(client, capture)
+	capture.headers = httpWriter
+	var writer io.Writer = httpWriter
+	var limited *limitWriter
+	if maxCGIOutput > 0 {
+		limited = &limitWriter{writer: writer, remaining: maxCGIOutput, onExceed: func() {}}
+		writer = limited
+	}
+
+	// Setup stderr logger
+	stderr := newStderrLogger(client.logger().With("backend", b.String()))
+
+	// Perform request
+	start := time.Now()
+	cgiExecutionsTotal.Inc()
+	client.cgiExitCode, err = b.protocol(conn, env, writer, stderr)
+	duration := time.Since(start)
+	cgiDuration.Observe(duration)
+	stderr.Flush()
+
+	// Parse HTTP headers (if present)
+	cgiStatusErr := httpWriter.FinishUp()
+
+	netErr, isNetErr := err.(net.Error)
+	switch {
+	case limited != nil && limited.exceeded:
+		client.logger().Warn(cgiOutputLimitStr, b.String(), maxCGIOutput, duration)
+		return NewError(CGIOutputLimitErr)
+	case isNetErr && netErr.Timeout():
+		cgiTimeoutsTotal.Inc()
+		client.logger().Warn(backendTimeoutStr, b.String(), duration)
+		return NewError(CGITimeoutErr)
+	case cgiStatusErr != nil:
+		return cgiStatusErr
+	case err != nil:
+		return WrapError(CGIBackendErr, err)
+	case client.cgiExitCode != 0:
+		client.logger().Error(cgiExecuteErrStr, b.String(), client.cgiExitCode, duration)
+		return NewError(CGIExitCodeErr)
+	default:
+		client.logger().Debug(cgiExecutedStr, b.String(), duration)
+		capture.Store(client, nil)
+		return nil
+	}
+}
@@ -40,8 +40,15 @@ func (lru *lruCacheMap) Get(key string) (*file, bool) {
 	return element.value, ok
 }
 
-// Put file in LRUCacheMap at key
-func (lru *lruCacheMap) Put(key string, value *file) {
+// Put file in LRUCacheMap at key, returning whether an old value was evicted
+func (lru *lruCacheMap) Put(key string, value *file) bool {
+	// If key already exists, update in place and move to front
+	if lElem, ok := lru.hashMap[key]; ok {
+		lElem.Value.(*element).value = value
+		lru.list.MoveToFront(lElem)
+		return false
+	}
+
 	lElem := lru.list.PushFront(&element{key, value})
 	lru.hashMap[key] = lElem
 
@@ -53,7 +60,9 @@ func (lru *lruCacheMap) Put(key string, value *file) {
 		// Delete entry in hashMap with key from Element, and from list
 		delete(lru.hashMap, element.key)
 		lru.list.Remove(lElem)
+		return true
 	}
+	return false
 }
 
 // Remove file in LRUCacheMap with key
@@ -68,6 +77,11 @@ func (lru *lruCacheMap) Remove(key string) {
 	lru.list.Remove(lElem)
 }
 
+// Len returns the number of entries currently in LRUCacheMap
+func (lru *lruCacheMap) Len() int {
+	return lru.list.Len()
+}
+
 // Iterate performs an iteration over all key:value pairs in LRUCacheMap with supplied function
 func (lru *lruCacheMap) Iterate(iterator func(key string, value *file)) {
 	for key := range lru.hashMap {
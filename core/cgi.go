@@ -1,10 +1,13 @@
 package core
 
 import (
-	"bytes"
+	"context"
 	"io"
+	"os"
 	"os/exec"
+	"path"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -16,19 +19,37 @@ var (
 	// maxCGIRunTime specifies the maximum time a CGI script can run for
 	maxCGIRunTime time.Duration
 
-	// httpPrefixBufSize specifies size of the buffer to use when skipping HTTP headers
-	httpPrefixBufSize int
+	// cgiKillGrace specifies the time a timed out CGI script has to exit after SIGTERM, before SIGKILL
+	cgiKillGrace time.Duration
 
 	// ExecuteCGIScript is a pointer to the currently set CGI execution function
 	ExecuteCGIScript func(*Client, *Request) Error
+
+	// cgiEnvFuncs holds registered functions returning protocol specific CGI environment variables
+	cgiEnvFuncs []func(*Client, *Request) []string
+
+	// cgiRedirect holds the registered function responding to a client with a protocol specific redirect
+	cgiRedirect func(*Client, string) Error
 )
 
+// RegisterCGIEnv registers a function returning protocol specific CGI environment variables for a request
+func RegisterCGIEnv(envFunc func(*Client, *Request) []string) {
+	cgiEnvFuncs = append(cgiEnvFuncs, envFunc)
+}
+
+// RegisterCGIRedirect registers the function used to respond with a redirect to location, when a CGI response sets a Location header
+func RegisterCGIRedirect(redirect func(client *Client, location string) Error) {
+	cgiRedirect = redirect
+}
+
 // setupInitialCGIEnv takes a safe PATH, uses other server variables and returns a slice of constant CGI environment variables
 func setupInitialCGIEnv(safePath string) []string {
 	env := make([]string, 0)
 
 	SystemLog.Info("CGI safe path: %s", safePath)
 	env = append(env, "PATH="+safePath)
+	env = append(env, "GATEWAY_INTERFACE=CGI/1.1")
+	env = append(env, "SERVER_SOFTWARE=gophor/"+Version)
 	env = append(env, "SERVER_NAME="+Hostname)
 	env = append(env, "SERVER_PORT="+FwdPort)
 	env = append(env, "DOCUMENT_ROOT="+Root)
@@ -36,296 +57,272 @@ func setupInitialCGIEnv(safePath string) []string {
 	return env
 }
 
-// generateCGIEnv takes a Client, and Request object, the global constant slice and generates a full set of CGI environment variables
+// generateCGIEnv takes a Client, and Request object, the global constant slice and generates a full set of CGI environment variables,
+// including those from registered protocol specific functions
 func generateCGIEnv(client *Client, request *Request) []string {
-	env := append(cgiEnv, "REMOTE_ADDR="+client.IP())
+	env := make([]string, 0, len(cgiEnv)+16)
+	env = append(env, cgiEnv...)
+	env = append(env, "REQUEST_METHOD=GET")
+	env = append(env, "REMOTE_ADDR="+client.IP())
+	env = append(env, "REMOTE_PORT="+client.Port())
+	env = append(env, "REQUEST_ID="+client.ID())
 	env = append(env, "QUERY_STRING="+request.Params())
-	env = append(env, "SCRIPT_NAME="+request.Path().Relative())
+	env = append(env, "SCRIPT_NAME="+formatSelector(request.Path().Relative()))
 	env = append(env, "SCRIPT_FILENAME="+request.Path().Absolute())
 	env = append(env, "SELECTOR="+request.Path().Selector())
 	env = append(env, "REQUEST_URI="+request.Path().Selector())
+	if request.PathInfo() != "" {
+		env = append(env, "PATH_INFO="+request.PathInfo())
+		env = append(env, "PATH_TRANSLATED="+path.Join(request.Path().Root(), request.PathInfo()))
+	}
+
+	for _, envFunc := range cgiEnvFuncs {
+		env = append(env, envFunc(client, request)...)
+	}
 
 	return env
 }
 
+// splitCGIPathInfo walks up a request's path looking for an existing regular file, remapping the request to it and
+// setting the remainder as path info. Returns whether found
+func splitCGIPathInfo(request *Request) bool {
+	rel := request.Path().Relative()
+	for dir := path.Dir(rel); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		stat, err := os.Stat(path.Join(request.Path().Root(), dir))
+		if err != nil {
+			// Doesn't exist, keep walking up
+			continue
+		} else if !stat.Mode().IsRegular() {
+			// Reached an existing dir (or other), there is no script
+			return false
+		}
+
+		request.pathInfo = strings.TrimPrefix(rel, dir)
+		request.Path().Remap(dir)
+		return true
+	}
+	return false
+}
+
 // executeCGIScriptNoHTTP executes a CGI script, responding with output to client without stripping HTTP headers
 func executeCGIScriptNoHTTP(client *Client, request *Request) Error {
-	return execute(client.Conn().Writer(), request.Path(), generateCGIEnv(client, request))
+	// Serve cached output if available
+	if err, ok := serveCachedCGIOutput(client, request); ok {
+		return err
+	}
+
+	// Capture output for caching, if configured
+	capture := newCGICapture(request, client.Conn().Writer(), nil)
+
+	var err Error
+	client.cgiExitCode, err = execute(client, capture, request.Path(), generateCGIEnv(client, request))
+	capture.Store(client, err)
+	return err
 }
 
 // executeCGIScriptStripHTTP executes a CGI script, responding with output to client, stripping HTTP headers and handling status code
 func executeCGIScriptStripHTTP(client *Client, request *Request) Error {
-	// Create new httpStripWriter
-	httpWriter := newhttpStripWriter(client.Conn().Writer())
+	// Serve cached output if available
+	if err, ok := serveCachedCGIOutput(client, request); ok {
+		return err
+	}
+
+	// Create new httpStripWriter, capturing stripped output for caching if configured or set by headers
+	capture := newCGICapture(request, client.Conn().Writer(), nil)
+	httpWriter := newhttpStripWriter(client, capture)
+	capture.headers = httpWriter
 
 	// Begin executing script
-	err := execute(httpWriter, request.Path(), generateCGIEnv(client, request))
+	var err Error
+	client.cgiExitCode, err = execute(client, httpWriter, request.Path(), generateCGIEnv(client, request))
 
-	// Parse HTTP headers (if present). Return error or continue letting output of script -> client
+	// Parse HTTP headers (if present). Execution errors (e.g. timeout) take precedence over any status set by the script
 	cgiStatusErr := httpWriter.FinishUp()
+	if err != nil {
+		return err
+	}
 	if cgiStatusErr != nil {
 		return cgiStatusErr
 	}
-	return err
+	capture.Store(client, nil)
+	return nil
 }
 
-// execute executes something at Path, with supplied environment and ouputing to writer
-func execute(writer io.Writer, p *Path, env []string) Error {
-	// Create cmd object
-	cmd := exec.Command(p.Absolute())
+// execute executes something at Path for client, with supplied environment and ouputing to writer, returning exit code.
+// Stderr output is logged line-by-line. If still running after maxCGIRunTime, or exceeding maxCGIOutput bytes of output,
+// the process group is stopped returning CGITimeoutErr or CGIOutputLimitErr respectively
+func execute(client *Client, writer io.Writer, p *Path, env []string) (int, Error) {
+	// Get credential to run as
+	credential, credErr := cgiCredentialFor(p)
+	if credErr != nil {
+		return -1, credErr
+	}
+
+	// Reserve slot within CGI concurrency limits
+	releaseSlot, ok := acquireCGISlot(p.Absolute())
+	if !ok {
+		return -1, NewError(CGIBusyErr)
+	}
+	defer releaseSlot()
+
+	ctx, cancel := context.WithTimeout(context.Background(), maxCGIRunTime)
+	defer cancel()
+
+	// Create cmd object, executing via the sandbox trampoline if enabled
+	var cmd *exec.Cmd
+	if cgiSandboxConfig != nil {
+		cmd = exec.Command(selfExecutable, cgiSandboxConfig.String(), p.Absolute())
+		cmd.Args[0] = cgiTrampolineArg0
+	} else {
+		cmd = exec.Command(p.Absolute())
+	}
 
-	// Set new process group id
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// Set new process group id (equal to the process id) so the whole group can be stopped, and credential if set
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: credential}
 
 	// Setup cmd environment
 	cmd.Env, cmd.Dir = env, p.Root()
 
-	// Setup cmd out writer
-	cmd.Stdout = writer
+	// Setup cmd out writer, capping output size if enabled
+	var limited *limitWriter
+	if maxCGIOutput > 0 {
+		limited = &limitWriter{writer: writer, remaining: maxCGIOutput, onExceed: cancel}
+		writer = limited
+	}
+
+	// Setup cmd stderr logger
+	stderr := newStderrLogger(client.logger().With("script", p.Absolute()))
 
-	// Start executing
-	err := cmd.Start()
+	// Setup stdout and stderr pipes. Output is copied by us rather than by os/exec, so the pipes can be closed if a
+	// process that left the process group (e.g. using setsid) still holds them open after the group is stopped
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return -1, WrapError(CGIStartErr, err)
+	}
+	stderrR, stderrW, err := os.Pipe()
 	if err != nil {
-		return WrapError(CGIStartErr, err)
+		stdoutR.Close()
+		stdoutW.Close()
+		return -1, WrapError(CGIStartErr, err)
 	}
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
 
-	// Setup goroutine to kill cmd after maxCGIRunTime
+	// Start executing, closing our copies of the pipe write ends
+	err = cmd.Start()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		return -1, WrapError(CGIStartErr, err)
+	}
+	start := time.Now()
+	cgiExecutionsTotal.Inc()
+
+	// Copy output, and wait (and so reap) in separate goroutines, allowing us to supervise
+	var copying sync.WaitGroup
+	copying.Add(2)
+	go copyCGIOutput(&copying, writer, stdoutR)
+	go copyCGIOutput(&copying, stderr, stderrR)
+	done := make(chan error, 1)
 	go func() {
-		// At least let the script try to finish...
-		time.Sleep(maxCGIRunTime)
-
-		// We've already finished
-		if cmd.ProcessState != nil {
-			return
-		}
-
-		// Get process group id
-		pgid, err := syscall.Getpgid(cmd.Process.Pid)
-		if err != nil {
-			SystemLog.Fatal(pgidNotFoundErrStr)
-		}
-
-		// Kill process group!
-		err = syscall.Kill(-pgid, syscall.SIGTERM)
-		if err != nil {
-			SystemLog.Fatal(pgidStopErrStr, pgid, err.Error())
-		}
+		err := cmd.Wait()
+		copying.Wait()
+		done <- err
 	}()
 
-	// Wait for command to finish, get exit code
-	err = cmd.Wait()
+	// Wait for command to finish, or stop the process group on timeout / output limit
+	stopped := false
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		stopped = true
+		err = stopProcessGroup(client, cmd.Process.Pid, done, stdoutR, stderrR)
+	}
+	duration := time.Since(start)
+	cgiDuration.Observe(duration)
+	stderr.Flush()
+
+	// Get exit code (-1 if killed by signal)
 	exitCode := 0
 	if err != nil {
-		// Error, try to get exit code
 		exitError, ok := err.(*exec.ExitError)
 		if ok {
-			waitStatus := exitError.Sys().(syscall.WaitStatus)
-			exitCode = waitStatus.ExitStatus()
+			exitCode = exitError.Sys().(syscall.WaitStatus).ExitStatus()
 		} else {
 			exitCode = 1
 		}
-	} else {
-		// No error! Get exit code directly from command process state
-		waitStatus := cmd.ProcessState.Sys().(syscall.WaitStatus)
-		exitCode = waitStatus.ExitStatus()
 	}
 
-	// Non-zero exit code? Return error
-	if exitCode != 0 {
-		SystemLog.Error(cgiExecuteErrStr, p.Absolute(), exitCode)
-		return NewError(CGIExitCodeErr)
+	switch {
+	case limited != nil && limited.exceeded:
+		client.logger().Warn(cgiOutputLimitStr, p.Absolute(), maxCGIOutput, duration)
+		return exitCode, NewError(CGIOutputLimitErr)
+	case stopped:
+		cgiTimeoutsTotal.Inc()
+		client.logger().Warn(cgiTimeoutStr, p.Absolute(), duration)
+		return exitCode, NewError(CGITimeoutErr)
+	case exitCode != 0:
+		client.logger().Error(cgiExecuteErrStr, p.Absolute(), exitCode, duration)
+		return exitCode, WrapError(CGIExitCodeErr, err)
+	default:
+		client.logger().Debug(cgiExecutedStr, p.Absolute(), duration)
+		return exitCode, nil
 	}
-
-	// Exit fine!
-	return nil
 }
 
-// httpStripWriter wraps a writer, reading HTTP headers and parsing status code, before deciding to continue writing
-type httpStripWriter struct {
-	writer     io.Writer
-	skipBuffer []byte
-	skipIndex  int
-	err        Error
-
-	// writeFunc is a pointer to the current underlying write function
-	writeFunc func(*httpStripWriter, []byte) (int, error)
+// copyCGIOutput copies output from a CGI process pipe to w until closed, then closes the pipe so the process receives
+// SIGPIPE on further writes (e.g. once w refuses output over the limit)
+func copyCGIOutput(wg *sync.WaitGroup, w io.Writer, r *os.File) {
+	io.Copy(w, r)
+	r.Close()
+	wg.Done()
 }
 
-// newhttpStripWriter returns a new httpStripWriter wrapping supplied writer
-func newhttpStripWriter(w io.Writer) *httpStripWriter {
-	return &httpStripWriter{
-		w,
-		make([]byte, httpPrefixBufSize),
-		0,
-		nil,
-		writeCheckForHeaders,
+// stopProcessGroup sends SIGTERM to a process group, escalating to SIGKILL if still running after cgiKillGrace. If
+// output is still held open cgiKillGrace after that (by a process that left the group), the output pipes are closed.
+// Returns the result of waiting on the process group leader, received from done
+func stopProcessGroup(client *Client, pgid int, done <-chan error, pipes ...*os.File) error {
+	err := syscall.Kill(-pgid, syscall.SIGTERM)
+	if err != nil {
+		client.logger().Warn(cgiSignalErrStr, syscall.SIGTERM, pgid, err.Error())
 	}
-}
 
-// addToSkipBuffer adds supplied bytes to the skip buffer, returning number added
-func (w *httpStripWriter) addToSkipBuffer(data []byte) int {
-	// Figure out amount to add
-	toAdd := len(w.skipBuffer) - w.skipIndex
-	if len(data) < toAdd {
-		toAdd = len(data)
+	select {
+	case err = <-done:
+		reapProcessGroup(pgid)
+		return err
+	case <-time.After(cgiKillGrace):
 	}
 
-	// Add data to skip buffer, return added
-	copy(w.skipBuffer[w.skipIndex:], data[:toAdd])
-	w.skipIndex += toAdd
-	return toAdd
-}
-
-// parseHTTPHeaderSection checks if we've received a valid HTTP header section, and determine if we should continue writing
-func (w *httpStripWriter) parseHTTPHeaderSection() (bool, bool) {
-	validHeaderSection, shouldContinue := false, true
-	for _, header := range strings.Split(string(w.skipBuffer), "\r\n") {
-		header = strings.ToLower(header)
-
-		// Try look for status header
-		lenBefore := len(header)
-		header = strings.TrimPrefix(header, "status:")
-		if len(header) < lenBefore {
-			// Ensure no spaces + just number
-			header = strings.Split(header, " ")[0]
-
-			// Ignore 200
-			if header == "200" {
-				continue
-			}
-
-			// Any other value indicates error, should not continue
-			shouldContinue = false
-
-			// Parse error code
-			code := CGIStatusUnknownErr
-			switch header {
-			case "400":
-				code = CGIStatus400Err
-			case "401":
-				code = CGIStatus401Err
-			case "403":
-				code = CGIStatus403Err
-			case "404":
-				code = CGIStatus404Err
-			case "408":
-				code = CGIStatus408Err
-			case "410":
-				code = CGIStatus410Err
-			case "500":
-				code = CGIStatus500Err
-			case "501":
-				code = CGIStatus501Err
-			case "503":
-				code = CGIStatus503Err
-			}
-
-			// Set error code
-			w.err = NewError(code)
-			continue
-		}
-
-		// Found a content-type header, this is a valid header section
-		if strings.Contains(header, "content-type:") {
-			validHeaderSection = true
-		}
+	err = syscall.Kill(-pgid, syscall.SIGKILL)
+	if err != nil {
+		client.logger().Warn(cgiSignalErrStr, syscall.SIGKILL, pgid, err.Error())
 	}
 
-	return validHeaderSection, shouldContinue
-}
-
-// writeSkipBuffer writes contents of skipBuffer to the underlying writer if necessary
-func (w *httpStripWriter) writeSkipBuffer() (bool, error) {
-	// Defer resetting skipIndex
-	defer func() {
-		w.skipIndex = 0
-	}()
-
-	// First try parse the headers, determine next steps
-	validHeaders, shouldContinue := w.parseHTTPHeaderSection()
-
-	// Valid headers received, don't bother writing. Return the shouldContinue value
-	if validHeaders {
-		return shouldContinue, nil
+	select {
+	case err = <-done:
+		reapProcessGroup(pgid)
+		return err
+	case <-time.After(cgiKillGrace):
 	}
 
-	// Default is to write skip buffer contents, shouldContinue only means something with valid headers
-	_, err := w.writer.Write(w.skipBuffer[:w.skipIndex])
-	return true, err
-}
-
-func (w *httpStripWriter) FinishUp() Error {
-	// If skipIndex not zero, try write (or at least parse and see if we need
-	// to write) remaining skipBuffer. (e.g. if CGI output very short)
-	if w.skipIndex > 0 {
-		w.writeSkipBuffer()
+	// Killed leader has exited but output is still held open, close our end of the pipes to stop copying
+	client.logger().Error(cgiOutputHeldStr, pgid, cgiKillGrace)
+	for _, pipe := range pipes {
+		pipe.Close()
 	}
-
-	// Return error if set
-	return w.err
-}
-
-func (w *httpStripWriter) Write(b []byte) (int, error) {
-	// Write using currently set write function
-	return w.writeFunc(w, b)
-}
-
-// writeRegular performs task of regular write function, it is a direct wrapper
-func writeRegular(w *httpStripWriter, b []byte) (int, error) {
-	return w.writer.Write(b)
+	err = <-done
+	reapProcessGroup(pgid)
+	return err
 }
 
-// writeCheckForHeaders reads input data, checking for headers to add to skip buffer and parse before continuing
-func writeCheckForHeaders(w *httpStripWriter, b []byte) (int, error) {
-	split := bytes.Split(b, []byte("\r\n\r\n"))
-	if len(split) == 1 {
-		// Headers found, try to add data to skip buffer
-		added := w.addToSkipBuffer(b)
-
-		if added < len(b) {
-			defer func() {
-				// Having written skip buffer, defer resetting write function
-				w.writeFunc = writeRegular
-			}()
-
-			doContinue, err := w.writeSkipBuffer()
-			if !doContinue {
-				return len(b), io.EOF
-			} else if err != nil {
-				return added, err
-			}
-
-			// Write remaining data not added to skip buffer
-			count, err := w.writer.Write(b[added:])
-			if err != nil {
-				return added + count, err
-			}
+// reapProcessGroup reaps any exited processes in a process group that were reparented to us (e.g. when running as PID 1)
+func reapProcessGroup(pgid int) {
+	for {
+		pid, err := syscall.Wait4(-pgid, nil, syscall.WNOHANG, nil)
+		if pid <= 0 || err != nil {
+			return
 		}
-
-		return len(b), nil
-	}
-
-	defer func() {
-		// No use for skip buffer after belo, set write to regular
-		w.writeFunc = writeRegular
-	}()
-
-	// Try add what we can to skip buffer
-	added := w.addToSkipBuffer(append(split[0], []byte("\r\n\r\n")...))
-
-	// Write skip buffer data if necessary, check if we should continue
-	doContinue, err := w.writeSkipBuffer()
-	if !doContinue {
-		return len(b), io.EOF
-	} else if err != nil {
-		return added, err
 	}
-
-	// Write remaining data not added to skip buffer, to writer
-	count, err := w.writer.Write(b[added:])
-	if err != nil {
-		return added + count, err
-	}
-
-	return len(b), nil
 }
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cgiCacheSeparatorStr specifies the separator string to recognise in CGI cache statements
+const cgiCacheSeparatorStr = " -> "
+
+var (
+	// cgiCachePaths is the global slice of per-path CGI output cache TTLs
+	cgiCachePaths []*CGICachePath
+
+	// cgiOutputCache holds cached CGI output, kept separate from the file cache so CGI output can't evict files
+	cgiOutputCache *lruCacheMap
+
+	// cgiOutputCacheLock protects cgiOutputCache (Get reorders entries, so all access is exclusive)
+	cgiOutputCacheLock sync.Mutex
+)
+
+// CGICachePath is a structure to hold a path regex to check against, and the TTL to cache matching CGI output for
+type CGICachePath struct {
+	Regex *regexp.Regexp
+	TTL   time.Duration
+}
+
+// compileCGICacheRegex turns a string of CGI cache statements into a slice of compiled CGICachePath structures
+func compileCGICacheRegex(caches string) []*CGICachePath {
+	cachePaths := make([]*CGICachePath, 0)
+
+	// Split caches string by new lines
+	for _, expr := range strings.Split(caches, "\n") {
+		// Skip empty expressions
+		if len(expr) == 0 {
+			continue
+		}
+
+		// Split into regex and TTL
+		split := strings.Split(expr, cgiCacheSeparatorStr)
+		if len(split) != 2 {
+			SystemLog.Fatal(cgiCachePathInvalidStr, expr)
+		}
+		ttl, err := time.ParseDuration(strings.TrimSpace(split[1]))
+		if err != nil || ttl < 0 {
+			SystemLog.Fatal(cgiCachePathInvalidStr, expr)
+		}
+
+		// Compile the regular expression
+		regex, err := regexp.Compile("(?m)" + strings.TrimPrefix(split[0], "/") + "$")
+		if err != nil {
+			SystemLog.Fatal(cgiCacheRegexCompileFailStr, expr)
+		}
+
+		// Append CGICachePath and log
+		cachePaths = append(cachePaths, &CGICachePath{regex, ttl})
+		SystemLog.Info(cgiCacheRegexCompiledStr, expr)
+	}
+
+	return cachePaths
+}
+
+// cgiCacheTTLFor returns the configured CGI output cache TTL for a Path, 0 if not cached
+func cgiCacheTTLFor(p *Path) time.Duration {
+	for _, cache := range cgiCachePaths {
+		if cache.Regex.MatchString(p.Relative()) {
+			return cache.TTL
+		}
+	}
+	return 0
+}
+
+// parseCGICacheTTL parses a TTL header value as either seconds or a duration string, returning success
+func parseCGICacheTTL(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if ttl, err := time.ParseDuration(value); err == nil && ttl >= 0 {
+		return ttl, true
+	}
+	return 0, false
+}
+
+// parseCacheControlTTL parses a Cache-Control header value, returning TTL from max-age (0 for no-store, no-cache
+// or private) and success
+func parseCacheControlTTL(value string) (time.Duration, bool) {
+	ttl, ok := time.Duration(0), false
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store" || directive == "no-cache" || directive == "private":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			ttl, ok = parseCGICacheTTL(strings.TrimPrefix(directive, "max-age="))
+		}
+	}
+	return ttl, ok
+}
+
+// cgiCacheKey returns the cache key for CGI output of a request, made up of the script path, path info and query string
+func cgiCacheKey(request *Request) string {
+	return request.Path().Absolute() + request.PathInfo() + "?" + request.Params()
+}
+
+// serveCachedCGIOutput writes unexpired cached CGI output for request to client, returning write Error and whether served
+func serveCachedCGIOutput(client *Client, request *Request) (Error, bool) {
+	// Look for unexpired cached output
+	cgiOutputCacheLock.Lock()
+	f, ok := cgiOutputCache.Get(cgiCacheKey(request))
+	cgiOutputCacheLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	f.RLock()
+	contents, ok := f.contents.(*cgiOutputContents)
+	f.RUnlock()
+	if !ok || contents.Expired() {
+		return nil, false
+	}
+
+	// Cached output is never modified, so no need to hold lock while writing
+	atomic.AddInt64(&FileSystem.hits, 1)
+	client.cacheHit = true
+	return contents.WriteToClient(client, request.Path()), true
+}
+
+// cgiCapture wraps a CGI output writer, capturing output for caching once the cache TTL is known
+type cgiCapture struct {
+	writer  io.Writer
+	key     string
+	ttl     time.Duration
+	headers *httpStripWriter
+	buf     []byte
+	started bool
+	skip    bool
+}
+
+// newCGICapture returns a new cgiCapture wrapping writer for request. Headers parsed by httpStripWriter (nil if not
+// used) may override the configured TTL
+func newCGICapture(request *Request, writer io.Writer, headers *httpStripWriter) *cgiCapture {
+	return &cgiCapture{
+		writer:  writer,
+		key:     cgiCacheKey(request),
+		ttl:     cgiCacheTTLFor(request.Path()),
+		headers: headers,
+	}
+}
+
+// cacheTTL returns the TTL to cache output for, header values taking precedence over configured
+func (c *cgiCapture) cacheTTL() time.Duration {
+	if c.headers != nil && c.headers.location != "" {
+		// Redirects are written directly to the client, never cached
+		return 0
+	}
+	if c.headers != nil && c.headers.ttlSet {
+		return c.headers.ttl
+	}
+	return c.ttl
+}
+
+// Write writes to the underlying writer, capturing output up to the max cached file size. Any headers are parsed
+// before first write reaches here, so it is then decided whether to capture
+func (c *cgiCapture) Write(b []byte) (int, error) {
+	if !c.started {
+		c.started = true
+		c.skip = c.cacheTTL() <= 0
+	}
+
+	n, err := c.writer.Write(b)
+	if !c.skip {
+		if int64(len(c.buf)+n) > fileSizeMax {
+			c.skip, c.buf = true, nil
+		} else {
+			c.buf = append(c.buf, b[:n]...)
+		}
+	}
+	return n, err
+}
+
+// Store caches captured output if execution succeeded and output was captured in full
+func (c *cgiCapture) Store(client *Client, err Error) {
+	ttl := c.cacheTTL()
+	if err != nil || c.skip || ttl <= 0 {
+		return
+	}
+
+	// Get cache lock, put output in cache (replacing any expired output)
+	cgiOutputCacheLock.Lock()
+	if cgiOutputCache.Put(c.key, newFile(&cgiOutputContents{c.buf, time.Now().Add(ttl)})) {
+		atomic.AddInt64(&FileSystem.evictions, 1)
+	}
+	cgiOutputCacheLock.Unlock()
+	atomic.AddInt64(&FileSystem.misses, 1)
+	client.logger().Debug(cgiOutputCachedStr, c.key, ttl)
+}
+
+// expireCGIOutput removes all expired output from the CGI output cache
+func expireCGIOutput() {
+	cgiOutputCacheLock.Lock()
+	defer cgiOutputCacheLock.Unlock()
+
+	// Gather expired keys first, we can't remove while iterating
+	expired := make([]string, 0)
+	cgiOutputCache.Iterate(func(key string, f *file) {
+		f.RLock()
+		contents, ok := f.contents.(*cgiOutputContents)
+		f.RUnlock()
+		if !ok || contents.Expired() {
+			expired = append(expired, key)
+		}
+	})
+
+	for _, key := range expired {
+		cgiOutputCache.Remove(key)
+	}
+}
+
+// cgiOutputStats returns the number of entries and total bytes in the CGI output cache
+func cgiOutputStats() (int, int64) {
+	cgiOutputCacheLock.Lock()
+	defer cgiOutputCacheLock.Unlock()
+
+	var size int64
+	cgiOutputCache.Iterate(func(key string, f *file) {
+		f.RLock()
+		size += int64(f.contents.Size())
+		f.RUnlock()
+	})
+	return cgiOutputCache.Len(), size
+}
+
+// cgiOutputKeys returns all keys currently in the CGI output cache
+func cgiOutputKeys() []string {
+	cgiOutputCacheLock.Lock()
+	defer cgiOutputCacheLock.Unlock()
+
+	keys := make([]string, 0, cgiOutputCache.Len())
+	cgiOutputCache.Iterate(func(key string, f *file) {
+		keys = append(keys, key)
+	})
+	return keys
+}
+
+// purgeCGIOutput removes all CGI output cache entries with key matching supplied function, returning number removed
+func purgeCGIOutput(match func(string) bool) int {
+	cgiOutputCacheLock.Lock()
+	defer cgiOutputCacheLock.Unlock()
+
+	// Gather matching keys first, we can't remove while iterating
+	toRemove := make([]string, 0)
+	cgiOutputCache.Iterate(func(key string, f *file) {
+		if match(key) {
+			toRemove = append(toRemove, key)
+		}
+	})
+
+	for _, key := range toRemove {
+		cgiOutputCache.Remove(key)
+	}
+	return len(toRemove)
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgiStderrLineMax is the max length of a CGI stderr line before it is logged regardless
+const cgiStderrLineMax = 4096
+
+// maxCGIOutput specifies the maximum number of bytes a CGI script may output, 0 to disable
+var maxCGIOutput int64
+
+// stderrLogger is a writer that logs each line written to it, used to capture CGI script stderr
+type stderrLogger struct {
+	logger loggerInterface
+	buf    []byte
+}
+
+// newStderrLogger returns a new stderrLogger logging to supplied logger
+func newStderrLogger(logger loggerInterface) *stderrLogger {
+	return &stderrLogger{logger: logger}
+}
+
+// Write buffers b, logging each complete line (or line exceeding max length)
+func (l *stderrLogger) Write(b []byte) (int, error) {
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			if len(l.buf) >= cgiStderrLineMax {
+				l.logLine(l.buf)
+				l.buf = l.buf[:0]
+			}
+			return len(b), nil
+		}
+
+		l.logLine(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// Flush logs any remaining partial line
+func (l *stderrLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.logLine(l.buf)
+		l.buf = nil
+	}
+}
+
+// logLine logs a single line, ignoring empty
+func (l *stderrLogger) logLine(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if len(line) > 0 {
+		l.logger.Warn(cgiStderrStr, line)
+	}
+}
+
+// limitWriter wraps a writer, calling onExceed and refusing further writes once the byte limit is exceeded
+type limitWriter struct {
+	writer    io.Writer
+	remaining int64
+	exceeded  bool
+	onExceed  func()
+}
+
+// Write writes to the underlying writer up to the remaining limit, returning error if exceeded
+func (w *limitWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) <= w.remaining {
+		n, err := w.writer.Write(b)
+		w.remaining -= int64(n)
+		return n, err
+	}
+
+	n, _ := w.writer.Write(b[:w.remaining])
+	w.remaining = 0
+	if !w.exceeded {
+		w.exceeded = true
+		w.onExceed()
+	}
+	return n, NewError(CGIOutputLimitErr)
+}
+
+// cgiStatusErrors maps CGI response Status codes to ErrorCodes, 2xx codes being success
+var cgiStatusErrors = map[int]ErrorCode{
+	400: CGIStatus400Err,
+	401: CGIStatus401Err,
+	403: CGIStatus403Err,
+	404: CGIStatus404Err,
+	408: CGIStatus408Err,
+	410: CGIStatus410Err,
+	500: CGIStatus500Err,
+	501: CGIStatus501Err,
+	502: CGIBackendErr,
+	503: CGIStatus503Err,
+	504: CGITimeoutErr,
+}
+
+// maxCGIHeaderSize specifies the maximum size of a CGI response header section
+var maxCGIHeaderSize int
+
+// httpStripWriter wraps a writer, parsing a leading CGI response header section before deciding whether to pass
+// through the body. Output not starting with a header section is passed through as-is
+type httpStripWriter struct {
+	writer io.Writer
+	client *Client
+	buf    []byte
+	state  int
+	err    Error
+
+	// headers holds the header lines parsed so far, and offset the start of the first unparsed line in buf
+	headers [][2]string
+	offset  int
+
+	// location holds the Location header value for redirects, empty if none
+	location string
+
+	// ttl holds the output cache TTL set by X-Gophor-TTL or Cache-Control headers, if ttlSet
+	ttl    time.Duration
+	ttlSet bool
+}
+
+// httpStripWriter states
+const (
+	headerPending = iota
+	headerPassthrough
+	headerDiscard
+)
+
+// newhttpStripWriter returns a new httpStripWriter for client wrapping supplied writer
+func newhttpStripWriter(client *Client, w io.Writer) *httpStripWriter {
+	return &httpStripWriter{
+		writer: w,
+		client: client,
+		state:  headerPending,
+	}
+}
+
+// Write buffers output until the header section is complete, then writes the body (or discards it if
+// the response was replaced by an error or redirect)
+func (w *httpStripWriter) Write(b []byte) (int, error) {
+	switch w.state {
+	case headerPassthrough:
+		return w.writer.Write(b)
+	case headerDiscard:
+		return len(b), nil
+	}
+
+	// Buffer and continue parsing header section from the last incomplete line
+	w.buf = append(w.buf, b...)
+	var complete, valid bool
+	w.headers, w.offset, complete, valid = parseCGIHeaderSection(w.buf, w.offset, w.headers)
+	switch {
+	case !valid:
+		// Not headers, pass through everything
+		return len(b), w.flush(w.buf)
+	case !complete && len(w.buf) > maxCGIHeaderSize:
+		// Header section too large
+		w.err = NewError(CGIHeaderSizeErr)
+		w.state, w.buf = headerDiscard, nil
+		return len(b), nil
+	case !complete:
+		// Wait for more
+		return len(b), nil
+	case w.offset > maxCGIHeaderSize:
+		w.err = NewError(CGIHeaderSizeErr)
+		w.state, w.buf = headerDiscard, nil
+		return len(b), nil
+	}
+
+	// Apply headers, writing remaining body if not replaced
+	if !w.applyHeaders(w.headers) {
+		return len(b), w.flush(w.buf)
+	}
+	return len(b), w.flush(w.buf[w.offset:])
+}
+
+// flush sets state according to parsed headers, writing supplied data if passing through
+func (w *httpStripWriter) flush(b []byte) error {
+	w.buf = nil
+	if w.err != nil || w.location != "" {
+		w.state = headerDiscard
+		return nil
+	}
+	w.state = headerPassthrough
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := w.writer.Write(b)
+	return err
+}
+
+// applyHeaders parses supplied CGI response headers, returning false if they're not a valid CGI header section
+// (requiring at least one of Status, Content-Type or Location)
+func (w *httpStripWriter) applyHeaders(headers [][2]string) bool {
+	status, statusSet, valid := 0, false, false
+	location, gophorTTL, cacheControl := "", "", ""
+	for _, header := range headers {
+		switch strings.ToLower(header[0]) {
+		case "status":
+			// Non-numeric status is treated as unknown
+			var err error
+			status, err = strconv.Atoi(strings.SplitN(header[1], " ", 2)[0])
+			if err != nil {
+				status = -1
+			}
+			statusSet, valid = true, true
+		case "content-type":
+			valid = true
+		case "location":
+			location, valid = header[1], true
+		case "x-gophor-ttl":
+			gophorTTL = header[1]
+		case "cache-control":
+			cacheControl = header[1]
+		}
+	}
+	if !valid {
+		return false
+	}
+
+	// Set cache TTL, X-Gophor-TTL taking precedence
+	if gophorTTL != "" {
+		w.ttl, w.ttlSet = parseCGICacheTTL(gophorTTL)
+	} else if cacheControl != "" {
+		w.ttl, w.ttlSet = parseCacheControlTTL(strings.ToLower(cacheControl))
+	}
+
+	// Determine response from status (Location implying redirect)
+	switch {
+	case location != "" && (!statusSet || (status >= 300 && status < 400)):
+		w.location = location
+	case !statusSet || (status >= 200 && status < 300):
+		// Success
+	default:
+		code, ok := cgiStatusErrors[status]
+		if !ok {
+			code = CGIStatusUnknownErr
+		}
+		w.err = NewError(code)
+	}
+	return true
+}
+
+// parseCGIHeaderSection parses header lines (terminated by \n or \r\n) in b from offset start until a blank line, appending
+// to supplied headers. Returns headers as name-value pairs, index of the body start (or of the incomplete line if not
+// complete), whether the section is complete and whether it is valid so far
+func parseCGIHeaderSection(b []byte, start int, headers [][2]string) ([][2]string, int, bool, bool) {
+	for {
+		end := bytes.IndexByte(b[start:], '\n')
+		if end < 0 {
+			// Incomplete line, check what we have so far looks like a header name
+			return headers, start, false, validHeaderName(b[start:], false)
+		}
+
+		line := bytes.TrimSuffix(b[start:start+end], []byte("\r"))
+		start += end + 1
+
+		// Blank line ends section, which must not be empty
+		if len(line) == 0 {
+			return headers, start, true, len(headers) > 0
+		}
+
+		// Split into name and value
+		split := bytes.IndexByte(line, ':')
+		if split < 0 || !validHeaderName(line[:split], true) {
+			return nil, start, false, false
+		}
+		headers = append(headers, [2]string{string(line[:split]), string(bytes.TrimSpace(line[split+1:]))})
+	}
+}
+
+// validHeaderName returns whether b is a valid header name, or a valid start to one if not complete
+func validHeaderName(b []byte, complete bool) bool {
+	if complete && len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case c == ':' && !complete:
+			// Rest of an incomplete header line is its value
+			return true
+		case c == '\r' && !complete && len(b) == 1:
+			// Start of a \r\n blank line
+			return true
+		case c <= ' ' || c >= 0x7f || strings.IndexByte("\"(),/;<=>?@[\\]{}", c) >= 0:
+			return false
+		}
+	}
+	return true
+}
+
+// FinishUp completes header parsing if output ended within the header section, performing any redirect.
+// Returns the response status Error
+func (w *httpStripWriter) FinishUp() Error {
+	if w.state == headerPending {
+		// Output ended before blank line, may still be a complete header section (e.g. just a Status line)
+		headers, _, _, valid := parseCGIHeaderSection(append(w.buf, '\n', '\n'), w.offset, w.headers)
+		if !valid || !w.applyHeaders(headers) {
+			w.flush(w.buf)
+		} else {
+			w.flush(nil)
+		}
+	}
+
+	// Perform redirect (if set and no error)
+	if w.err == nil && w.location != "" {
+		if cgiRedirect == nil {
+			return NewError(CGIStatusUnknownErr)
+		}
+		return cgiRedirect(w.client, w.location)
+	}
+
+	return w.err
+}
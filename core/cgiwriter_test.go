@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// stripTestResult holds the outcome of passing CGI output through an httpStripWriter
+type stripTestResult struct {
+	body     string // written to the wrapped writer
+	redirect string // written to the client by cgiRedirect
+	err      Error
+}
+
+// runStripWriter writes each chunk of output to a new httpStripWriter in turn, then finishes up
+func runStripWriter(t *testing.T, chunks []string) stripTestResult {
+	cgiRedirect = func(client *Client, location string) Error {
+		return client.Conn().WriteBytes([]byte(location))
+	}
+	defer func() { cgiRedirect = nil }()
+
+	client, output := newTestClient(t)
+	body := &bytes.Buffer{}
+	w := newhttpStripWriter(client, body)
+	for _, chunk := range chunks {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Errorf("Write(%q) = %d, %v", chunk, n, err)
+		}
+	}
+	err := w.FinishUp()
+	return stripTestResult{body.String(), output(), err}
+}
+
+// splitEvery splits s into chunks of n bytes
+func splitEvery(s string, n int) []string {
+	chunks := make([]string, 0, len(s)/n+1)
+	for len(s) > n {
+		chunks, s = append(chunks, s[:n]), s[n:]
+	}
+	return append(chunks, s)
+}
+
+func TestHTTPStripWriter(t *testing.T) {
+	setupTestServer(t)
+	maxCGIHeaderSize = 64
+	longHeader := "Content-Type: text/plain\r\nX-Padding: " + strings.Repeat("a", 64) + "\r\n\r\nbody"
+
+	for _, test := range []struct {
+		name   string
+		chunks []string
+		want   stripTestResult
+	}{
+		{"crlf", []string{"Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nbody"}, stripTestResult{body: "body"}},
+		{"lf", []string{"Content-Type: text/plain\n\nbody\n"}, stripTestResult{body: "body\n"}},
+		{"mixed line endings", []string{"Status: 200\nContent-Type: text/plain\r\n\nbody"}, stripTestResult{body: "body"}},
+		{"lowercase names", []string{"status: 204\r\ncontent-type: text/plain\r\n\r\nbody"}, stripTestResult{body: "body"}},
+		{"split every byte", splitEvery("Status: 200 OK\r\nContent-Type: text/plain\r\n\r\nbody", 1), stripTestResult{body: "body"}},
+		{"split within crlf", []string{"Content-Type: text/plain\r", "\n\r", "\nbo", "dy"}, stripTestResult{body: "body"}},
+		{"split after headers", []string{"Content-Type: text/plain\r\n\r\n", "bo", "dy"}, stripTestResult{body: "body"}},
+		{"headers only", []string{"Content-Type: text/plain\r\n\r\n"}, stripTestResult{}},
+		{"unterminated section", []string{"Content-Type: text/plain"}, stripTestResult{}},
+		{"not headers", []string{"iWelcome\tfake\tnull.host\t0\r\n.\r\n"}, stripTestResult{body: "iWelcome\tfake\tnull.host\t0\r\n.\r\n"}},
+		{"invalid name", []string{"Bad Name: x\r\n\r\nbody"}, stripTestResult{body: "Bad Name: x\r\n\r\nbody"}},
+		{"invalid name character", []string{"Content/Type: text/plain\r\n\r\nbody"}, stripTestResult{body: "Content/Type: text/plain\r\n\r\nbody"}},
+		{"missing colon", []string{"Content-Type: text/plain\r\nnot a header\r\n\r\nbody"}, stripTestResult{body: "Content-Type: text/plain\r\nnot a header\r\n\r\nbody"}},
+		{"invalid name split", []string{"Content-Type: text/plain\r\nBad", " Name: x\r\n\r\n"}, stripTestResult{body: "Content-Type: text/plain\r\nBad Name: x\r\n\r\n"}},
+		{"no cgi header", []string{"X-Other: 1\r\n\r\nbody"}, stripTestResult{body: "X-Other: 1\r\n\r\nbody"}},
+		{"empty section", []string{"\r\nbody"}, stripTestResult{body: "\r\nbody"}},
+		{"too large", []string{longHeader}, stripTestResult{err: NewError(CGIHeaderSizeErr)}},
+		{"too large split", splitEvery(longHeader, 8), stripTestResult{err: NewError(CGIHeaderSizeErr)}},
+		{"too large unterminated", splitEvery(strings.Repeat("X-Padding: aaaa\r\n", 8), 5), stripTestResult{err: NewError(CGIHeaderSizeErr)}},
+		{"status error", []string{"Status: 404 Not Found\r\nContent-Type: text/plain\r\n\r\nnot here"}, stripTestResult{err: NewError(CGIStatus404Err)}},
+		{"status only", []string{"Status: 503"}, stripTestResult{err: NewError(CGIStatus503Err)}},
+		{"non-numeric status", []string{"Status: OK\r\nContent-Type: text/plain\r\n\r\nbody"}, stripTestResult{err: NewError(CGIStatusUnknownErr)}},
+		{"empty status", []string{"Status:\r\n\r\nbody"}, stripTestResult{err: NewError(CGIStatusUnknownErr)}},
+		{"location", []string{"Location: /elsewhere\r\n\r\nignored"}, stripTestResult{redirect: "/elsewhere"}},
+		{"location split", splitEvery("Location: gopher://example.com/1/\r\n\r\n", 3), stripTestResult{redirect: "gopher://example.com/1/"}},
+		{"location with redirect status", []string{"Status: 302 Found\r\nLocation: /elsewhere\r\n\r\n"}, stripTestResult{redirect: "/elsewhere"}},
+		{"location with success status", []string{"Status: 200\r\nLocation: /elsewhere\r\nContent-Type: text/plain\r\n\r\nbody"}, stripTestResult{body: "body"}},
+		{"location with error status", []string{"Status: 500\r\nLocation: /elsewhere\r\n\r\n"}, stripTestResult{err: NewError(CGIStatus500Err)}},
+		{"redirect status without location", []string{"Status: 301\r\n\r\n"}, stripTestResult{err: NewError(CGIStatusUnknownErr)}},
+	} {
+		got := runStripWriter(t, test.chunks)
+		if got.body != test.want.body {
+			t.Errorf("%s: body %q, want %q", test.name, got.body, test.want.body)
+		}
+		if got.redirect != test.want.redirect {
+			t.Errorf("%s: redirect %q, want %q", test.name, got.redirect, test.want.redirect)
+		}
+		switch {
+		case test.want.err == nil && got.err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, got.err)
+		case test.want.err != nil && (got.err == nil || got.err.Code() != test.want.err.Code()):
+			t.Errorf("%s: error %v, want %v", test.name, got.err, test.want.err)
+		}
+	}
+}
+
+func TestHTTPStripWriterParseOffset(t *testing.T) {
+	setupTestServer(t)
+	client, output := newTestClient(t)
+	defer output()
+
+	// Completed lines are parsed once, the offset left at the start of the incomplete line
+	w := newhttpStripWriter(client, &bytes.Buffer{})
+	w.Write([]byte("Status: 200\r\nContent-"))
+	if w.offset != len("Status: 200\r\n") || len(w.headers) != 1 {
+		t.Fatalf("offset %d with %d headers after first write", w.offset, len(w.headers))
+	}
+	w.Write([]byte("Type: text/plain\r\n"))
+	if w.offset != len("Status: 200\r\nContent-Type: text/plain\r\n") || len(w.headers) != 2 {
+		t.Fatalf("offset %d with %d headers after second write", w.offset, len(w.headers))
+	}
+	if w.headers[1] != [2]string{"Content-Type", "text/plain"} {
+		t.Errorf("parsed header %q", w.headers[1])
+	}
+}
+
+func TestCGIStatusErrors(t *testing.T) {
+	setupTestServer(t)
+
+	for status, code := range cgiStatusErrors {
+		got := runStripWriter(t, []string{"Status: " + strconv.Itoa(status) + " Reason\r\n\r\nbody"})
+		if got.err == nil || got.err.Code() != code {
+			t.Errorf("status %d: error %v, want code %d", status, got.err, code)
+		}
+		if got.body != "" {
+			t.Errorf("status %d: body %q written", status, got.body)
+		}
+	}
+
+	// 2xx is success, unmapped codes are unknown
+	for status, code := range map[int]ErrorCode{200: 0, 204: 0, 299: 0, 100: CGIStatusUnknownErr, 418: CGIStatusUnknownErr, 599: CGIStatusUnknownErr} {
+		got := runStripWriter(t, []string{"Status: " + strconv.Itoa(status) + "\r\n\r\nbody"})
+		switch {
+		case code == 0 && (got.err != nil || got.body != "body"):
+			t.Errorf("status %d: error %v, body %q, want success", status, got.err, got.body)
+		case code != 0 && (got.err == nil || got.err.Code() != code):
+			t.Errorf("status %d: error %v, want code %d", status, got.err, code)
+		}
+	}
+}
@@ -2,21 +2,112 @@ package core
 
 import (
 	"net"
+	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// activeClients holds the set of currently connected clients
+	activeClients = make(map[*Client]struct{})
+
+	// activeClientsLock protects activeClients
+	activeClientsLock sync.Mutex
+
+	// activeClientsWait waits on all currently connected clients
+	activeClientsWait sync.WaitGroup
+
+	// activeClientsClosed is set (under activeClientsLock) once draining, after which no more clients are tracked
+	activeClientsClosed bool
 )
 
 // Client holds onto an open Conn to a client, along with connection information
 type Client struct {
-	cn   *conn
-	ip   *net.IP
-	port string
+	id          string
+	cn          *conn
+	ip          *net.IP
+	port        string
+	start       time.Time
+	request     atomic.Value
+	cacheHit    bool
+	cgiExitCode int
 }
 
 // NewClient returns a new client based on supplied net.TCPConn
 func NewClient(conn *net.TCPConn) *Client {
 	addr, _ := conn.RemoteAddr().(*net.TCPAddr)
 	ip, port := &addr.IP, strconv.Itoa(addr.Port)
-	return &Client{wrapConn(conn), ip, port}
+	client := &Client{
+		id:          nextRequestID(),
+		cn:          wrapConn(conn),
+		ip:          ip,
+		port:        port,
+		start:       time.Now(),
+		cgiExitCode: -1,
+	}
+
+	// Attach tracer if tracing enabled
+	if tracingEnabled {
+		client.cn.dc.tracer = newTracer(client)
+	}
+
+	return client
+}
+
+// trackClient adds a client to the set of active clients, returning false if no longer tracking as draining
+func trackClient(client *Client) bool {
+	activeClientsLock.Lock()
+	defer activeClientsLock.Unlock()
+	if activeClientsClosed {
+		return false
+	}
+	activeClients[client] = struct{}{}
+	activeClientsWait.Add(1)
+	return true
+}
+
+// waitActiveClients stops tracking new clients, then returns a channel closed once all active clients are finished
+func waitActiveClients() <-chan struct{} {
+	activeClientsLock.Lock()
+	activeClientsClosed = true
+	activeClientsLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		activeClientsWait.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// untrackClient removes a client from the set of active clients
+func untrackClient(client *Client) {
+	activeClientsLock.Lock()
+	delete(activeClients, client)
+	activeClientsWait.Done()
+	activeClientsLock.Unlock()
+}
+
+// ActiveClients returns a slice of currently connected clients, sorted by connection time
+func ActiveClients() []*Client {
+	activeClientsLock.Lock()
+	clients := make([]*Client, 0, len(activeClients))
+	for client := range activeClients {
+		clients = append(clients, client)
+	}
+	activeClientsLock.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].start.Before(clients[j].start)
+	})
+	return clients
+}
+
+// ID returns the client's unique request ID
+func (c *Client) ID() string {
+	return c.id
 }
 
 // Conn returns the underlying conn
@@ -34,12 +125,59 @@ func (c *Client) Port() string {
 	return c.port
 }
 
-// LogInfo logs to the global access logger with the client IP as a prefix
+// Request returns the client's current request, or nil if not yet known
+func (c *Client) Request() *Request {
+	request, _ := c.request.Load().(*Request)
+	return request
+}
+
+// setRequest sets the client's current request
+func (c *Client) setRequest(request *Request) {
+	c.request.Store(request)
+}
+
+// Selector returns the selector the client requested, or empty string if not yet known
+func (c *Client) Selector() string {
+	if request := c.Request(); request != nil {
+		return request.Path().Selector()
+	}
+	return ""
+}
+
+// Duration returns the time elapsed since the client connected
+func (c *Client) Duration() time.Duration {
+	return time.Since(c.start)
+}
+
+// logFields returns the client's key/value log fields
+func (c *Client) logFields() []interface{} {
+	params := ""
+	if request := c.Request(); request != nil {
+		params = request.Params()
+	}
+
+	return []interface{}{
+		"request_id", c.id,
+		"ip", c.IP(),
+		"port", c.port,
+		"selector", c.Selector(),
+		"params", params,
+		"bytes", c.cn.Written(),
+		"duration_ms", float64(c.Duration().Microseconds()) / 1000,
+	}
+}
+
+// logger returns the global system logger with the client's request fields attached
+func (c *Client) logger() loggerInterface {
+	return SystemLog.With(c.logFields()...)
+}
+
+// LogInfo logs to the global system logger with the client's request fields
 func (c *Client) LogInfo(fmt string, args ...interface{}) {
-	AccessLog.Info("("+c.ip.String()+") "+fmt, args...)
+	c.logger().Info(fmt, args...)
 }
 
-// LogError logs to the global access logger with the client IP as a prefix
-func (c *Client) LogError(fmt string, args ...interface{}) {
-	AccessLog.Error("("+c.ip.String()+") "+fmt, args...)
+// LogError logs to the global system logger with the client's request fields and supplied Error
+func (c *Client) LogError(err Error, fmt string, args ...interface{}) {
+	c.logger().With("error_code", int(err.Code()), "error", err.Error(), "context", err.Context()).Error(fmt, args...)
 }
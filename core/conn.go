@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +15,19 @@ var (
 	// connWriteDeadline specifies the connection write deadline
 	connWriteDeadline time.Duration
 
+	// requestTimeout specifies the total time allowed to receive a request, 0 to disable
+	requestTimeout time.Duration
+
+	// responseTimeout specifies the total time allowed to send a static response, 0 to disable
+	responseTimeout time.Duration
+
+	// cgiResponseTimeout specifies the total time allowed to send a CGI response, 0 to disable
+	cgiResponseTimeout time.Duration
+
+	// minTransferRate specifies the minimum response transfer rate (in bytes/sec), extending the response timeout (or the
+	// write deadline if no timeout) by the time taken to send the bytes written so far at this rate, 0 to disable
+	minTransferRate float64
+
 	// connReadBufSize specifies the connection read buffer size
 	connReadBufSize int
 
@@ -24,21 +38,171 @@ var (
 	connReadMax int
 )
 
-// deadlineConn wraps net.Conn to set the read / write deadlines on each access
+// deadlineConn wraps net.Conn to set the read / write deadlines on each access, counting bytes written. Alongside
+// the per-access deadlines, absolute deadlines bound the total time to receive a request and send a response
 type deadlineConn struct {
-	conn net.Conn
+	written        int64 // only accessed atomically (kept first for 64-bit alignment)
+	conn           net.Conn
+	tracer         *tracer      // nil if tracing disabled
+	throttle       *tokenBucket // nil if per-connection throttling disabled
+	readBy         time.Time    // zero if no absolute read deadline
+	readErr        error        // last read error, as bufio drops errors on partially read lines
+	writeStart     time.Time    // zero if no absolute write deadline
+	writeTimeout   time.Duration
+	writtenAtStart int64
+	throttleWaited time.Duration // time spent waiting on rate limits since response start
+}
+
+// startRequest begins the request absolute deadline with supplied timeout (0 to disable)
+func (c *deadlineConn) startRequest(timeout time.Duration) {
+	if timeout > 0 {
+		c.readBy = time.Now().Add(timeout)
+	} else {
+		c.readBy = time.Time{}
+	}
+}
+
+// startResponse begins the response absolute deadline with supplied timeout. A timeout of 0 disables the deadline,
+// unless a minimum transfer rate is set in which case the write deadline is used as the base allowance
+func (c *deadlineConn) startResponse(timeout time.Duration) {
+	if timeout <= 0 && minTransferRate > 0 {
+		timeout = connWriteDeadline
+	}
+	if timeout > 0 {
+		c.writeStart = time.Now()
+	} else {
+		c.writeStart = time.Time{}
+	}
+	c.writeTimeout = timeout
+	c.writtenAtStart = atomic.LoadInt64(&c.written)
+	c.throttleWaited = 0
+}
+
+// readDeadline returns the deadline for the next read, the sooner of per-access and absolute request deadlines
+func (c *deadlineConn) readDeadline() time.Time {
+	deadline := time.Now().Add(connReadDeadline)
+	if !c.readBy.IsZero() && c.readBy.Before(deadline) {
+		return c.readBy
+	}
+	return deadline
+}
+
+// writeDeadline returns the deadline for the next write, the sooner of per-access and absolute response deadlines.
+// The absolute deadline is extended by the time spent waiting on rate limits, and by the time taken to send bytes
+// written so far at the minimum transfer rate
+func (c *deadlineConn) writeDeadline() time.Time {
+	deadline := time.Now().Add(connWriteDeadline)
+	if c.writeStart.IsZero() {
+		return deadline
+	}
+
+	writeBy := c.writeStart.Add(c.writeTimeout + c.throttleWaited)
+	if minTransferRate > 0 {
+		sent := atomic.LoadInt64(&c.written) - c.writtenAtStart
+		writeBy = writeBy.Add(time.Duration(float64(sent) / minTransferRate * float64(time.Second)))
+	}
+	if writeBy.Before(deadline) {
+		return writeBy
+	}
+	return deadline
 }
 
 // Read wraps the underlying net.Conn read function, setting read deadline on each access
 func (c *deadlineConn) Read(b []byte) (int, error) {
-	c.conn.SetReadDeadline(time.Now().Add(connReadDeadline))
-	return c.conn.Read(b)
+	c.conn.SetReadDeadline(c.readDeadline())
+	n, err := c.conn.Read(b)
+	c.readErr = err
+	return n, err
 }
 
-// Read wraps the underlying net.Conn write function, setting write deadline on each access
+// Write wraps the underlying net.Conn write function, throttling writes to the per-connection and global rates if enabled
 func (c *deadlineConn) Write(b []byte) (int, error) {
-	c.conn.SetWriteDeadline(time.Now().Add(connWriteDeadline))
-	return c.conn.Write(b)
+	if !c.throttled() {
+		return c.write(b)
+	}
+
+	// Write in chunks, waiting on each enabled rate limit before each
+	written := 0
+	for written < len(b) {
+		chunk := b[written:]
+		if len(chunk) > throttleChunkSize {
+			chunk = chunk[:throttleChunkSize]
+		}
+		waitStart := time.Now()
+		if c.throttle != nil {
+			c.throttle.wait(len(chunk))
+		}
+		if globalThrottle != nil {
+			globalThrottle.wait(len(chunk))
+		}
+		c.throttleWaited += time.Since(waitStart)
+
+		n, err := c.write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// throttled returns whether writes to this conn are rate limited
+func (c *deadlineConn) throttled() bool {
+	return c.throttle != nil || globalThrottle != nil
+}
+
+// write wraps the underlying net.Conn write function, setting write deadline on each access
+func (c *deadlineConn) write(b []byte) (int, error) {
+	c.conn.SetWriteDeadline(c.writeDeadline())
+	n, err := c.conn.Write(b)
+	if c.tracer != nil {
+		c.tracer.write(b[:n])
+	}
+	atomic.AddInt64(&c.written, int64(n))
+	bytesSentTotal.Add(int64(n))
+	return n, err
+}
+
+// writeBuffers writes supplied buffers to the underlying net.Conn (using vectored writes where supported), setting write deadline first.
+// If throttled, buffers are instead written sequentially so rate limits apply
+func (c *deadlineConn) writeBuffers(bufs net.Buffers) (int64, error) {
+	if c.throttled() {
+		var total int64
+		for _, b := range bufs {
+			n, err := c.Write(b)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	}
+
+	// Keep a copy of buffers for tracing, WriteTo consumes them
+	var traced net.Buffers
+	if c.tracer != nil {
+		traced = append(traced, bufs...)
+	}
+
+	c.conn.SetWriteDeadline(c.writeDeadline())
+	n, err := bufs.WriteTo(c.conn)
+
+	// Trace only the bytes actually written
+	remaining := n
+	for _, b := range traced {
+		if remaining <= 0 {
+			break
+		}
+		if int64(len(b)) > remaining {
+			b = b[:remaining]
+		}
+		c.tracer.write(b)
+		remaining -= int64(len(b))
+	}
+
+	atomic.AddInt64(&c.written, n)
+	bytesSentTotal.Add(n)
+	return n, err
 }
 
 // Close directly wraps underlying net.Conn close function
@@ -48,13 +212,13 @@ func (c *deadlineConn) Close() error {
 
 // Conn wraps a DeadlineConn with a buffer
 type conn struct {
-	buf    *bufio.ReadWriter
-	closer io.Closer
+	buf *bufio.ReadWriter
+	dc  *deadlineConn
 }
 
 // wrapConn wraps a net.Conn in DeadlineConn, then within Conn and returns the result
 func wrapConn(c net.Conn) *conn {
-	deadlineConn := &deadlineConn{c}
+	deadlineConn := &deadlineConn{conn: c, throttle: newConnThrottle(throttleConnRate)}
 	buf := bufio.NewReadWriter(
 		bufio.NewReaderSize(deadlineConn, connReadBufSize),
 		bufio.NewWriterSize(deadlineConn, connWriteBufSize),
@@ -83,6 +247,17 @@ func (c *conn) ReadLine() ([]byte, Error) {
 		}
 	}
 
+	// Partial line due to timeout, don't treat as complete request
+	if netErr, ok := c.dc.readErr.(net.Error); ok && netErr.Timeout() {
+		return nil, WrapError(ConnReadErr, netErr)
+	}
+
+	if c.dc.tracer != nil {
+		c.dc.tracer.request(b)
+	}
+
+	// Request received, begin response deadline
+	c.dc.startResponse(responseTimeout)
 	return b, nil
 }
 
@@ -95,6 +270,20 @@ func (c *conn) WriteBytes(b []byte) Error {
 	return nil
 }
 
+// WriteBuffers flushes the buffer then writes the supplied byte slices directly to the conn, as a single vectored write where possible
+func (c *conn) WriteBuffers(bufs net.Buffers) Error {
+	err := c.buf.Flush()
+	if err != nil {
+		return WrapError(ConnWriteErr, err)
+	}
+
+	_, err = c.dc.writeBuffers(bufs)
+	if err != nil {
+		return WrapError(ConnWriteErr, err)
+	}
+	return nil
+}
+
 // WriteFrom writes to the buffer from a reader and returns error status
 func (c *conn) WriteFrom(r io.Reader) Error {
 	_, err := c.buf.ReadFrom(r)
@@ -104,6 +293,11 @@ func (c *conn) WriteFrom(r io.Reader) Error {
 	return nil
 }
 
+// Written returns the number of bytes written to the underlying net.Conn so far
+func (c *conn) Written() int64 {
+	return atomic.LoadInt64(&c.dc.written)
+}
+
 // Writer returns the underlying buffer wrapped conn writer
 func (c *conn) Writer() io.Writer {
 	return c.buf.Writer
@@ -112,7 +306,7 @@ func (c *conn) Writer() io.Writer {
 // Close flushes the underlying buffer then closes the conn
 func (c *conn) Close() Error {
 	err := c.buf.Flush()
-	err = c.closer.Close()
+	err = c.dc.Close()
 	if err != nil {
 		return WrapError(ConnCloseErr, err)
 	}
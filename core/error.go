@@ -1,147 +1,237 @@
 package core
 
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
 // ErrorCode specifies types of errors for later identification
 type ErrorCode int
 
 // Core ErrorCodes
 const (
-	ConnWriteErr        ErrorCode = -1
-	ConnReadErr         ErrorCode = -2
-	ConnCloseErr        ErrorCode = -3
-	ListenerResolveErr  ErrorCode = -4
-	ListenerBeginErr    ErrorCode = -5
-	ListenerAcceptErr   ErrorCode = -6
-	InvalidIPErr        ErrorCode = -7
-	InvalidPortErr      ErrorCode = -8
-	FileOpenErr         ErrorCode = -9
-	FileStatErr         ErrorCode = -10
-	FileReadErr         ErrorCode = -11
-	FileTypeErr         ErrorCode = -12
-	DirectoryReadErr    ErrorCode = -13
-	RestrictedPathErr   ErrorCode = -14
-	InvalidRequestErr   ErrorCode = -15
-	CGIStartErr         ErrorCode = -16
-	CGIExitCodeErr      ErrorCode = -17
-	CGIStatus400Err     ErrorCode = -18
-	CGIStatus401Err     ErrorCode = -19
-	CGIStatus403Err     ErrorCode = -20
-	CGIStatus404Err     ErrorCode = -21
-	CGIStatus408Err     ErrorCode = -22
-	CGIStatus410Err     ErrorCode = -23
-	CGIStatus500Err     ErrorCode = -24
-	CGIStatus501Err     ErrorCode = -25
-	CGIStatus503Err     ErrorCode = -26
-	CGIStatusUnknownErr ErrorCode = -27
+	ConnWriteErr           ErrorCode = -1
+	ConnReadErr            ErrorCode = -2
+	ConnCloseErr           ErrorCode = -3
+	ListenerResolveErr     ErrorCode = -4
+	ListenerBeginErr       ErrorCode = -5
+	ListenerAcceptErr      ErrorCode = -6
+	InvalidIPErr           ErrorCode = -7
+	InvalidPortErr         ErrorCode = -8
+	FileOpenErr            ErrorCode = -9
+	FileStatErr            ErrorCode = -10
+	FileReadErr            ErrorCode = -11
+	FileTypeErr            ErrorCode = -12
+	DirectoryReadErr       ErrorCode = -13
+	RestrictedPathErr      ErrorCode = -14
+	InvalidRequestErr      ErrorCode = -15
+	CGIStartErr            ErrorCode = -16
+	CGIExitCodeErr         ErrorCode = -17
+	CGIStatus400Err        ErrorCode = -18
+	CGIStatus401Err        ErrorCode = -19
+	CGIStatus403Err        ErrorCode = -20
+	CGIStatus404Err        ErrorCode = -21
+	CGIStatus408Err        ErrorCode = -22
+	CGIStatus410Err        ErrorCode = -23
+	CGIStatus500Err        ErrorCode = -24
+	CGIStatus501Err        ErrorCode = -25
+	CGIStatus503Err        ErrorCode = -26
+	CGIStatusUnknownErr    ErrorCode = -27
+	AdminListenErr         ErrorCode = -28
+	InvalidAdminCommandErr ErrorCode = -29
+	InvalidAdminArgsErr    ErrorCode = -30
+	MetricsListenErr       ErrorCode = -31
+	ServePanicErr          ErrorCode = -32
+	ServerBusyErr          ErrorCode = -33
+	CGITimeoutErr          ErrorCode = -34
+	CGIOutputLimitErr      ErrorCode = -35
+	CGIBusyErr             ErrorCode = -36
+	CGIBackendErr          ErrorCode = -37
+	CGIHeaderSizeErr       ErrorCode = -38
 )
 
-// Error specifies error interface with identifiable ErrorCode
-type Error interface {
-	Code() ErrorCode
-	Error() string
+// Error returns the registered message for an ErrorCode, allowing ErrorCodes to be used as
+// targets for errors.Is(), e.g. errors.Is(err, FileOpenErr)
+func (code ErrorCode) Error() string {
+	return getErrorMessage(code)
+}
+
+// ErrorResponse generates a protocol specific response for an Error
+type ErrorResponse func(Error) []byte
+
+var (
+	// errorMessages maps ErrorCodes to string messages, both core and those registered by protocols
+	errorMessages = map[ErrorCode]string{
+		ConnWriteErr:           connWriteErrStr,
+		ConnReadErr:            connReadErrStr,
+		ConnCloseErr:           connCloseErrStr,
+		ListenerResolveErr:     listenerResolveErrStr,
+		ListenerBeginErr:       listenerBeginErrStr,
+		ListenerAcceptErr:      listenerAcceptErrStr,
+		InvalidIPErr:           invalidIPErrStr,
+		InvalidPortErr:         invalidPortErrStr,
+		FileOpenErr:            fileOpenErrStr,
+		FileStatErr:            fileStatErrStr,
+		FileReadErr:            fileReadErrStr,
+		FileTypeErr:            fileTypeErrStr,
+		DirectoryReadErr:       directoryReadErrStr,
+		RestrictedPathErr:      restrictedPathErrStr,
+		InvalidRequestErr:      invalidRequestErrStr,
+		CGIStartErr:            cgiStartErrStr,
+		CGIExitCodeErr:         cgiExitCodeErrStr,
+		CGIStatus400Err:        cgiStatus400ErrStr,
+		CGIStatus401Err:        cgiStatus401ErrStr,
+		CGIStatus403Err:        cgiStatus403ErrStr,
+		CGIStatus404Err:        cgiStatus404ErrStr,
+		CGIStatus408Err:        cgiStatus408ErrStr,
+		CGIStatus410Err:        cgiStatus410ErrStr,
+		CGIStatus500Err:        cgiStatus500ErrStr,
+		CGIStatus501Err:        cgiStatus501ErrStr,
+		CGIStatus503Err:        cgiStatus503ErrStr,
+		CGIStatusUnknownErr:    cgiStatusUnknownErrStr,
+		AdminListenErr:         adminListenErrStr,
+		InvalidAdminCommandErr: invalidAdminCommandErrStr,
+		InvalidAdminArgsErr:    invalidAdminArgsErrStr,
+		MetricsListenErr:       metricsListenErrStr,
+		ServePanicErr:          servePanicErrStr,
+		ServerBusyErr:          serverBusyErrStr,
+		CGITimeoutErr:          cgiTimeoutErrStr,
+		CGIOutputLimitErr:      cgiOutputLimitErrStr,
+		CGIBusyErr:             cgiBusyErrStr,
+		CGIBackendErr:          cgiBackendErrStr,
+		CGIHeaderSizeErr:       cgiHeaderSizeErrStr,
+	}
+
+	// errorResponses maps ErrorCodes to protocol specific responses, as registered by protocols
+	errorResponses = make(map[ErrorCode]ErrorResponse)
+
+	// errorTablesLock protects errorMessages and errorResponses
+	errorTablesLock sync.RWMutex
+)
+
+// RegisterErrorMessages adds supplied ErrorCode messages to the error message table, replacing any existing
+func RegisterErrorMessages(messages map[ErrorCode]string) {
+	errorTablesLock.Lock()
+	defer errorTablesLock.Unlock()
+	for code, message := range messages {
+		errorMessages[code] = message
+	}
 }
 
-// getExtendedErrorMessage converts an ErrorCode to string message
-var getExtendedErrorMessage func(ErrorCode) string
+// RegisterErrorResponses adds supplied ErrorCode responses to the error response table, replacing any existing.
+// Errors with ErrorCodes that have no registered response are not responded to
+func RegisterErrorResponses(responses map[ErrorCode]ErrorResponse) {
+	errorTablesLock.Lock()
+	defer errorTablesLock.Unlock()
+	for code, response := range responses {
+		errorResponses[code] = response
+	}
+}
 
-// getErrorMessage converts an ErrorCode to string message first checking internal codes, next user supplied
+// getErrorMessage converts an ErrorCode to string message using the error message table
 func getErrorMessage(code ErrorCode) string {
-	switch code {
-	case ConnWriteErr:
-		return connWriteErrStr
-	case ConnReadErr:
-		return connReadErrStr
-	case ConnCloseErr:
-		return connCloseErrStr
-	case ListenerResolveErr:
-		return listenerResolveErrStr
-	case ListenerBeginErr:
-		return listenerBeginErrStr
-	case ListenerAcceptErr:
-		return listenerAcceptErrStr
-	case InvalidIPErr:
-		return invalidIPErrStr
-	case InvalidPortErr:
-		return invalidPortErrStr
-	case FileOpenErr:
-		return fileOpenErrStr
-	case FileStatErr:
-		return fileStatErrStr
-	case FileReadErr:
-		return fileReadErrStr
-	case FileTypeErr:
-		return fileTypeErrStr
-	case DirectoryReadErr:
-		return directoryReadErrStr
-	case RestrictedPathErr:
-		return restrictedPathErrStr
-	case InvalidRequestErr:
-		return invalidRequestErrStr
-	case CGIStartErr:
-		return cgiStartErrStr
-	case CGIExitCodeErr:
-		return cgiExitCodeErrStr
-	case CGIStatus400Err:
-		return cgiStatus400ErrStr
-	case CGIStatus401Err:
-		return cgiStatus401ErrStr
-	case CGIStatus403Err:
-		return cgiStatus403ErrStr
-	case CGIStatus404Err:
-		return cgiStatus404ErrStr
-	case CGIStatus408Err:
-		return cgiStatus408ErrStr
-	case CGIStatus410Err:
-		return cgiStatus410ErrStr
-	case CGIStatus500Err:
-		return cgiStatus500ErrStr
-	case CGIStatus501Err:
-		return cgiStatus501ErrStr
-	case CGIStatus503Err:
-		return cgiStatus503ErrStr
-	case CGIStatusUnknownErr:
-		return cgiStatusUnknownErrStr
-	default:
-		return getExtendedErrorMessage(code)
+	errorTablesLock.RLock()
+	message, ok := errorMessages[code]
+	errorTablesLock.RUnlock()
+	if !ok {
+		return unknownErrStr + " " + strconv.Itoa(int(code))
 	}
+	return message
 }
 
-// regularError simply holds an ErrorCode
-type regularError struct {
-	code ErrorCode
+// GetErrorResponse returns the registered protocol response for an Error, and whether one exists
+func GetErrorResponse(err Error) ([]byte, bool) {
+	errorTablesLock.RLock()
+	response, ok := errorResponses[err.Code()]
+	errorTablesLock.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return response(err), true
 }
 
-// Error returns the error string for the underlying ErrorCode
-func (e *regularError) Error() string {
-	return getErrorMessage(e.code)
+// Error specifies error interface with identifiable ErrorCode, optional underlying cause and
+// optional path or selector context
+type Error interface {
+	error
+	Code() ErrorCode
+	Context() string
+	Unwrap() error
+}
+
+// codeError is the single implementation of Error
+type codeError struct {
+	code    ErrorCode
+	err     error  // underlying cause, nil if none
+	context string // path or selector, empty if none
+}
+
+// Error returns the error string for the ErrorCode, with context and underlying error if set
+func (e *codeError) Error() string {
+	str := getErrorMessage(e.code)
+	if e.context != "" {
+		str += " (" + e.context + ")"
+	}
+	if e.err != nil {
+		str += " - " + e.err.Error()
+	}
+	return str
 }
 
 // Code returns the underlying ErrorCode
-func (e *regularError) Code() ErrorCode {
+func (e *codeError) Code() ErrorCode {
 	return e.code
 }
 
-// NewError returns a new Error based on supplied ErrorCode
-func NewError(code ErrorCode) Error {
-	return &regularError{code}
+// Context returns the path or selector the Error occurred for, or empty string
+func (e *codeError) Context() string {
+	return e.context
 }
 
-// wrappedError wraps an existing error with new ErrorCode
-type wrappedError struct {
-	code ErrorCode
-	err  error
+// Unwrap returns the underlying error, or nil
+func (e *codeError) Unwrap() error {
+	return e.err
 }
 
-// Error returns the error string for underlying error and set ErrorCode
-func (e *wrappedError) Error() string {
-	return getErrorMessage(e.code) + " - " + e.err.Error()
+// Is returns whether target is this Error's ErrorCode, or another Error with the same ErrorCode
+func (e *codeError) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return t == e.code
+	case Error:
+		return t.Code() == e.code
+	default:
+		return false
+	}
 }
 
-// Code returns the underlying ErrorCode
-func (e *wrappedError) Code() ErrorCode {
-	return e.code
+// NewError returns a new Error based on supplied ErrorCode
+func NewError(code ErrorCode) Error {
+	return &codeError{code: code}
 }
 
 // WrapError returns a new Error based on supplied error and ErrorCode
 func WrapError(code ErrorCode, err error) Error {
-	return &wrappedError{code, err}
+	return &codeError{code: code, err: err}
+}
+
+// WithContext returns a copy of an Error with the path or selector context set, keeping any existing context
+func WithContext(err Error, context string) Error {
+	if err == nil || err.Context() != "" {
+		return err
+	}
+	return &codeError{err.Code(), err.Unwrap(), context}
+}
+
+// isTemporaryError returns whether an Error wraps a temporary accept error (out of file descriptors, aborted
+// connection or timeout) that is worth backing off and retrying
+func isTemporaryError(err Error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// FastCGI record types and constants used by the responder client
+const (
+	fcgiVersion       = 1
+	fcgiBeginRequest  = 1
+	fcgiEndRequest    = 3
+	fcgiParams        = 4
+	fcgiStdin         = 5
+	fcgiStdout        = 6
+	fcgiStderr        = 7
+	fcgiRoleResponder = 1
+	fcgiRequestID     = 1
+	fcgiHeaderLen     = 8
+	fcgiMaxContentLen = 65535
+)
+
+// errFastCGIProtocol is returned on unexpected FastCGI records
+var errFastCGIProtocol = errors.New("fastcgi protocol error")
+
+// fastCGIWriteRecord writes a single FastCGI record of type with content
+func fastCGIWriteRecord(w io.Writer, recType byte, content []byte) error {
+	header := [fcgiHeaderLen]byte{fcgiVersion, recType}
+	binary.BigEndian.PutUint16(header[2:], fcgiRequestID)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// fastCGIWriteStream writes data as a FastCGI stream of type, split into max length records and terminated by an empty record
+func fastCGIWriteStream(w io.Writer, recType byte, data []byte) error {
+	for len(data) > 0 {
+		n := len(data)
+		if n > fcgiMaxContentLen {
+			n = fcgiMaxContentLen
+		}
+		if err := fastCGIWriteRecord(w, recType, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return fastCGIWriteRecord(w, recType, nil)
+}
+
+// fastCGIAppendLength appends a FastCGI name-value pair length, 1 byte if < 128 else 4 bytes with high bit set
+func fastCGIAppendLength(b []byte, length int) []byte {
+	if length < 128 {
+		return append(b, byte(length))
+	}
+	return append(b, byte(length>>24)|0x80, byte(length>>16), byte(length>>8), byte(length))
+}
+
+// fastCGIEncodeParams encodes a CGI environment as FastCGI name-value pairs
+func fastCGIEncodeParams(env []string) []byte {
+	b := make([]byte, 0, 1024)
+	for _, kv := range env {
+		name, value := splitBy(kv, "=")
+		b = fastCGIAppendLength(b, len(name))
+		b = fastCGIAppendLength(b, len(value))
+		b = append(b, name...)
+		b = append(b, value...)
+	}
+	return b
+}
+
+// serveFastCGI implements backendProtocol for FastCGI responders, using a new connection per request
+func serveFastCGI(conn net.Conn, env []string, stdout, stderr io.Writer) (int, error) {
+	// Send request: begin (without keep-conn), params, then empty stdin
+	w := bufio.NewWriter(conn)
+	begin := []byte{0, fcgiRoleResponder, 0, 0, 0, 0, 0, 0}
+	if err := fastCGIWriteRecord(w, fcgiBeginRequest, begin); err != nil {
+		return -1, err
+	}
+	if err := fastCGIWriteStream(w, fcgiParams, fastCGIEncodeParams(env)); err != nil {
+		return -1, err
+	}
+	if err := fastCGIWriteRecord(w, fcgiStdin, nil); err != nil {
+		return -1, err
+	}
+	if err := w.Flush(); err != nil {
+		return -1, err
+	}
+
+	// Read response records until end request
+	r := bufio.NewReader(conn)
+	header := make([]byte, fcgiHeaderLen)
+	content := make([]byte, fcgiMaxContentLen+255)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return -1, err
+		}
+		if header[0] != fcgiVersion || binary.BigEndian.Uint16(header[2:]) != fcgiRequestID {
+			return -1, errFastCGIProtocol
+		}
+		contentLen := int(binary.BigEndian.Uint16(header[4:]))
+		paddingLen := int(header[6])
+		body := content[:contentLen+paddingLen]
+		if _, err := io.ReadFull(r, body); err != nil {
+			return -1, err
+		}
+		body = body[:contentLen]
+
+		switch header[1] {
+		case fcgiStdout:
+			if _, err := stdout.Write(body); err != nil {
+				return -1, err
+			}
+		case fcgiStderr:
+			stderr.Write(body)
+		case fcgiEndRequest:
+			if contentLen < 5 {
+				return -1, errFastCGIProtocol
+			}
+			appStatus := int(binary.BigEndian.Uint32(body))
+			if body[4] != 0 {
+				return appStatus, fmt.Errorf("fastcgi request rejected, protocol status %d", body[4])
+			}
+			return appStatus, nil
+		default:
+			return -1, errFastCGIProtocol
+		}
+	}
+}
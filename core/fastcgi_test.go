@@ -0,0 +1,315 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fcgiTestRecord is a FastCGI record as received by the test responder
+type fcgiTestRecord struct {
+	version byte
+	recType byte
+	id      uint16
+	content []byte
+}
+
+// fcgiTestReadRecord reads a single FastCGI record from r
+func fcgiTestReadRecord(r io.Reader) (*fcgiTestRecord, error) {
+	header := make([]byte, fcgiHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	body := make([]byte, int(binary.BigEndian.Uint16(header[4:]))+int(header[6]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &fcgiTestRecord{header[0], header[1], binary.BigEndian.Uint16(header[2:]), body[:binary.BigEndian.Uint16(header[4:])]}, nil
+}
+
+// fcgiTestWriteRecord writes a single FastCGI record to w, with supplied padding length
+func fcgiTestWriteRecord(w io.Writer, version, recType byte, id uint16, content []byte, padding int) {
+	header := []byte{version, recType, 0, 0, 0, 0, byte(padding), 0}
+	binary.BigEndian.PutUint16(header[2:], id)
+	binary.BigEndian.PutUint16(header[4:], uint16(len(content)))
+	w.Write(header)
+	w.Write(content)
+	w.Write(make([]byte, padding))
+}
+
+// fcgiTestEndRequest returns END_REQUEST record content with supplied app and protocol status
+func fcgiTestEndRequest(appStatus uint32, protocolStatus byte) []byte {
+	content := make([]byte, 8)
+	binary.BigEndian.PutUint32(content, appStatus)
+	content[4] = protocolStatus
+	return content
+}
+
+// fcgiTestDecodeParams decodes FastCGI name-value pairs into a map
+func fcgiTestDecodeParams(t *testing.T, b []byte) map[string]string {
+	readLength := func() int {
+		if b[0]&0x80 == 0 {
+			length := int(b[0])
+			b = b[1:]
+			return length
+		}
+		length := int(binary.BigEndian.Uint32(b) &^ (1 << 31))
+		b = b[4:]
+		return length
+	}
+
+	params := make(map[string]string)
+	for len(b) > 0 {
+		nameLen, valueLen := readLength(), readLength()
+		if len(b) < nameLen+valueLen {
+			t.Errorf("truncated name-value pair")
+			break
+		}
+		params[string(b[:nameLen])] = string(b[nameLen : nameLen+valueLen])
+		b = b[nameLen+valueLen:]
+	}
+	return params
+}
+
+// fcgiTestResponder starts a FastCGI responder on a unix socket, returning its path. For each connection the received
+// records are read up to and including the empty STDIN record, then passed to respond along with the decoded params
+func fcgiTestResponder(t *testing.T, respond func(w io.Writer, records []*fcgiTestRecord, params map[string]string)) string {
+	dir, err := ioutil.TempDir("", "gophor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "fcgi.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				records := make([]*fcgiTestRecord, 0)
+				params := make([]byte, 0)
+				r := bufio.NewReader(conn)
+				for {
+					record, err := fcgiTestReadRecord(r)
+					if err != nil {
+						t.Errorf("responder read: %v", err)
+						return
+					}
+					records = append(records, record)
+					if record.recType == fcgiParams {
+						params = append(params, record.content...)
+					}
+					if record.recType == fcgiStdin && len(record.content) == 0 {
+						break
+					}
+				}
+
+				w := bufio.NewWriter(conn)
+				respond(w, records, fcgiTestDecodeParams(t, params))
+				w.Flush()
+			}()
+		}
+	}()
+
+	return path
+}
+
+// dialTest dials the unix socket at path, failing the test on error
+func dialTest(t *testing.T, path string) net.Conn {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServeFastCGI(t *testing.T) {
+	// Long values exercise 4 byte lengths and splitting params over multiple records
+	env := []string{
+		"SHORT=value",
+		"LONG=" + strings.Repeat("l", 200),
+		"HUGE=" + strings.Repeat("h", 70000),
+		"EMPTY=",
+	}
+
+	records := make(chan []*fcgiTestRecord, 1)
+	params := make(chan map[string]string, 1)
+	path := fcgiTestResponder(t, func(w io.Writer, recs []*fcgiTestRecord, p map[string]string) {
+		records <- recs
+		params <- p
+
+		// Interleaved output, with padding
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiStdout, fcgiRequestID, []byte("Content-Type: text/plain\r\n\r\nhello "), 3)
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiStderr, fcgiRequestID, []byte("warning\n"), 0)
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiStdout, fcgiRequestID, []byte("world"), 7)
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiStdout, fcgiRequestID, nil, 0)
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiStderr, fcgiRequestID, nil, 0)
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiEndRequest, fcgiRequestID, fcgiTestEndRequest(7, 0), 0)
+	})
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	status, err := serveFastCGI(dialTest(t, path), env, stdout, stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 7 {
+		t.Errorf("app status = %d, want 7", status)
+	}
+	if got := stdout.String(); got != "Content-Type: text/plain\r\n\r\nhello world" {
+		t.Errorf("stdout = %q", got)
+	}
+	if got := stderr.String(); got != "warning\n" {
+		t.Errorf("stderr = %q", got)
+	}
+
+	// Check request framing: BEGIN_REQUEST, PARAMS stream, empty STDIN
+	recs := <-records
+	for _, record := range recs {
+		if record.version != fcgiVersion || record.id != fcgiRequestID {
+			t.Errorf("record type %d: version %d, id %d", record.recType, record.version, record.id)
+		}
+		if len(record.content) > fcgiMaxContentLen {
+			t.Errorf("record type %d: content length %d", record.recType, len(record.content))
+		}
+	}
+	begin := recs[0]
+	if begin.recType != fcgiBeginRequest || !bytes.Equal(begin.content, []byte{0, fcgiRoleResponder, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("first record type %d, content %v, want responder BEGIN_REQUEST without keep-conn", begin.recType, begin.content)
+	}
+	paramRecords := recs[1 : len(recs)-1]
+	if len(paramRecords) < 3 {
+		t.Errorf("%d PARAMS records, want stream split over at least 2 records plus terminator", len(paramRecords))
+	}
+	for i, record := range paramRecords {
+		if record.recType != fcgiParams {
+			t.Errorf("record %d type %d, want PARAMS", i+1, record.recType)
+		}
+	}
+	if last := paramRecords[len(paramRecords)-1]; len(last.content) != 0 {
+		t.Errorf("PARAMS stream not terminated by empty record")
+	}
+	if stdin := recs[len(recs)-1]; stdin.recType != fcgiStdin || len(stdin.content) != 0 {
+		t.Errorf("last record type %d, want empty STDIN", stdin.recType)
+	}
+
+	p := <-params
+	if len(p) != len(env) {
+		t.Errorf("received %d params, want %d", len(p), len(env))
+	}
+	for _, kv := range env {
+		name, value := splitBy(kv, "=")
+		if p[name] != value {
+			t.Errorf("param %s = %.20q..., want %.20q...", name, p[name], value)
+		}
+	}
+}
+
+func TestServeFastCGIRejectsInvalidRecords(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		version byte
+		recType byte
+		id      uint16
+		content []byte
+	}{
+		{"bad version", 2, fcgiStdout, fcgiRequestID, []byte("out")},
+		{"bad request id", fcgiVersion, fcgiStdout, fcgiRequestID + 1, []byte("out")},
+		{"bad end request id", fcgiVersion, fcgiEndRequest, 0, fcgiTestEndRequest(0, 0)},
+		{"unknown type", fcgiVersion, 11, fcgiRequestID, nil},
+		{"short end request", fcgiVersion, fcgiEndRequest, fcgiRequestID, []byte{0, 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := fcgiTestResponder(t, func(w io.Writer, _ []*fcgiTestRecord, _ map[string]string) {
+				fcgiTestWriteRecord(w, test.version, test.recType, test.id, test.content, 0)
+				fcgiTestWriteRecord(w, fcgiVersion, fcgiEndRequest, fcgiRequestID, fcgiTestEndRequest(0, 0), 0)
+			})
+
+			stdout := &bytes.Buffer{}
+			_, err := serveFastCGI(dialTest(t, path), nil, stdout, ioutil.Discard)
+			if err != errFastCGIProtocol {
+				t.Errorf("err = %v, want %v", err, errFastCGIProtocol)
+			}
+			if stdout.Len() > 0 {
+				t.Errorf("stdout = %q, want nothing written", stdout.String())
+			}
+		})
+	}
+}
+
+func TestServeFastCGIRequestRejected(t *testing.T) {
+	// Protocol status 2 = FCGI_OVERLOADED
+	path := fcgiTestResponder(t, func(w io.Writer, _ []*fcgiTestRecord, _ map[string]string) {
+		fcgiTestWriteRecord(w, fcgiVersion, fcgiEndRequest, fcgiRequestID, fcgiTestEndRequest(0, 2), 0)
+	})
+
+	if _, err := serveFastCGI(dialTest(t, path), nil, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected error for rejected request")
+	}
+}
+
+func TestFastCGIBackendRouting(t *testing.T) {
+	setupTestServer(t)
+
+	// Each backend responds with its name, script name and path info
+	newBackend := func(prefix, name string) *cgiBackend {
+		path := fcgiTestResponder(t, func(w io.Writer, _ []*fcgiTestRecord, p map[string]string) {
+			out := "Content-Type: text/plain\r\n\r\n" + name + " " + p["SCRIPT_NAME"] + " " + p["PATH_INFO"] + " " + p["QUERY_STRING"]
+			fcgiTestWriteRecord(w, fcgiVersion, fcgiStdout, fcgiRequestID, []byte(out), 0)
+			fcgiTestWriteRecord(w, fcgiVersion, fcgiStdout, fcgiRequestID, nil, 0)
+			fcgiTestWriteRecord(w, fcgiVersion, fcgiEndRequest, fcgiRequestID, fcgiTestEndRequest(0, 0), 0)
+		})
+		return &cgiBackend{prefix, "unix", path, serveFastCGI}
+	}
+	addBackends([]*cgiBackend{newBackend("/app", "app"), newBackend("/app/admin", "admin")})
+	t.Cleanup(func() { cgiBackends = nil })
+
+	for _, test := range []struct {
+		selector string
+		want     string
+	}{
+		{"/app", "app /app  "},
+		{"/app/", "app /app  "},
+		{"/app/page?q=1", "app /app /page q=1"},
+		{"/app/admin", "admin /app/admin  "},
+		{"/app/admin/users/1", "admin /app/admin /users/1 "},
+		{"/app/administrator", "app /app /administrator "},
+	} {
+		request, err := ParseURLEncodedRequest(test.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		client, output := newTestClient(t)
+		if err := FileSystem.handleRequest(client, request, nil, nil); err != nil {
+			t.Errorf("%s: %v", test.selector, err)
+		}
+		if got := output(); got != test.want {
+			t.Errorf("%s: response %q, want %q", test.selector, got, test.want)
+		}
+	}
+
+	// Prefixes only match whole selector elements
+	for _, selector := range []string{"/apple", "/ap", "/"} {
+		if backend, ok := matchBackend(getRequestPath(selector)); ok {
+			t.Errorf("%s: matched backend %s", selector, backend.prefix)
+		}
+	}
+}
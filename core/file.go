@@ -6,16 +6,6 @@ import (
 	"time"
 )
 
-// isGeneratedType just checks if a file's contents implemented is GeneratedFileContents
-func isGeneratedType(f *file) bool {
-	switch f.contents.(type) {
-	case *generatedFileContents:
-		return true
-	default:
-		return false
-	}
-}
-
 // file provides a structure for managing a cached file including freshness, last refresh time etc
 type file struct {
 	contents    FileContents
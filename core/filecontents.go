@@ -1,12 +1,16 @@
 package core
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // FileContents provides an interface for caching, rendering and getting cached contents of a file
 type FileContents interface {
 	WriteToClient(*Client, *Path) Error
 	Load(*os.File, *Path) Error
 	Clear()
+	Size() int
 }
 
 // generatedFileContents is a simple FileContents implementation for holding onto a generated (virtual) file contents
@@ -25,6 +29,63 @@ func (fc *generatedFileContents) Load(fd *os.File, path *Path) Error { return ni
 // Clear does nothing
 func (fc *generatedFileContents) Clear() {}
 
+// Size returns the generated file contents size in bytes
+func (fc *generatedFileContents) Size() int {
+	return len(fc.content)
+}
+
+// dynamicFileContents is a FileContents implementation for a generated (virtual) file whose contents are regenerated on each request
+type dynamicFileContents struct {
+	generate func(*Client, *Path) ([]byte, Error)
+}
+
+// WriteToClient generates the file contents and writes them to the client
+func (fc *dynamicFileContents) WriteToClient(client *Client, path *Path) Error {
+	b, err := fc.generate(client, path)
+	if err != nil {
+		return err
+	}
+	return client.Conn().WriteBytes(b)
+}
+
+// Load does nothing
+func (fc *dynamicFileContents) Load(fd *os.File, path *Path) Error { return nil }
+
+// Clear does nothing
+func (fc *dynamicFileContents) Clear() {}
+
+// Size returns zero, nothing is held in memory
+func (fc *dynamicFileContents) Size() int {
+	return 0
+}
+
+// cgiOutputContents is a FileContents implementation holding cached CGI output until expiry
+type cgiOutputContents struct {
+	content []byte
+	expires time.Time
+}
+
+// WriteToClient writes the cached CGI output to the client
+func (fc *cgiOutputContents) WriteToClient(client *Client, path *Path) Error {
+	return client.Conn().WriteBytes(fc.content)
+}
+
+// Load does nothing
+func (fc *cgiOutputContents) Load(fd *os.File, path *Path) Error { return nil }
+
+// Clear does nothing
+func (fc *cgiOutputContents) Clear() {}
+
+// Size returns the cached CGI output size in bytes
+func (fc *cgiOutputContents) Size() int {
+	return len(fc.content)
+}
+
+// Expired returns whether the cached CGI output has expired
+func (fc *cgiOutputContents) Expired() bool {
+	return time.Now().After(fc.expires)
+}
+
 // RegularFileContents is the simplest implementation of core.FileContents for regular files
 type RegularFileContents struct {
 	contents []byte
@@ -46,3 +107,8 @@ func (fc *RegularFileContents) Load(fd *os.File, path *Path) Error {
 func (fc *RegularFileContents) Clear() {
 	fc.contents = nil
 }
+
+// Size returns the currently cached FileContents size in bytes
+func (fc *RegularFileContents) Size() int {
+	return len(fc.contents)
+}
@@ -4,8 +4,12 @@ import (
 	"bufio"
 	"io"
 	"os"
+	"path"
+	"regexp"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,17 +30,37 @@ var (
 	userDir string
 )
 
+// CacheStats holds a snapshot of FileSystemObject cache statistics, including cached CGI output
+type CacheStats struct {
+	Entries   int
+	Bytes     int64
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Reloads   int64
+}
+
 // FileSystemObject holds onto an LRUCacheMap and manages access to it, handless freshness checking and multi-threading
 type FileSystemObject struct {
+	// cache counters, only accessed atomically (kept first for 64-bit alignment)
+	hits      int64
+	misses    int64
+	evictions int64
+	reloads   int64
+
 	cache *lruCacheMap
+
+	// generated holds generated and dynamic files, kept separate from the LRU cache so they are never evicted
+	generated map[string]*file
 	sync.RWMutex
 }
 
 // NewFileSystemObject returns a new FileSystemObject
 func newFileSystemObject(size int) *FileSystemObject {
 	return &FileSystemObject{
-		newLRUCacheMap(size),
-		sync.RWMutex{},
+		cache:     newLRUCacheMap(size),
+		generated: make(map[string]*file),
+		RWMutex:   sync.RWMutex{},
 	}
 }
 
@@ -46,8 +70,9 @@ func (fs *FileSystemObject) StartMonitor() {
 		// Sleep to not take up all the precious CPU time :)
 		time.Sleep(monitorSleepTime)
 
-		// Check file cache freshness
+		// Check file cache freshness, and expire cached CGI output
 		fs.checkCacheFreshness()
+		expireCGIOutput()
 	}
 }
 
@@ -57,15 +82,10 @@ func (fs *FileSystemObject) checkCacheFreshness() {
 	fs.Lock()
 
 	fs.cache.Iterate(func(path string, f *file) {
-		// If this is a generated file we skip
-		if isGeneratedType(f) {
-			return
-		}
-
 		// Check file still exists on disk
 		stat, err := os.Stat(path)
 		if err != nil {
-			SystemLog.Error("Failed to stat file in cache: %s\n", path)
+			SystemLog.Warn("Failed to stat file in cache: %s", path)
 			fs.cache.Remove(path)
 			return
 		}
@@ -81,6 +101,104 @@ func (fs *FileSystemObject) checkCacheFreshness() {
 	fs.Unlock()
 }
 
+// Stats returns a snapshot of the current cache statistics
+func (fs *FileSystemObject) Stats() CacheStats {
+	// Start with cached CGI output totals
+	entries, size := cgiOutputStats()
+
+	// Get cache read lock, defer unlock
+	fs.RLock()
+	defer fs.RUnlock()
+
+	// Total up cached contents size
+	fs.cache.Iterate(func(path string, f *file) {
+		f.RLock()
+		size += int64(f.contents.Size())
+		f.RUnlock()
+	})
+
+	return CacheStats{
+		Entries:   entries + fs.cache.Len(),
+		Bytes:     size,
+		Hits:      atomic.LoadInt64(&fs.hits),
+		Misses:    atomic.LoadInt64(&fs.misses),
+		Evictions: atomic.LoadInt64(&fs.evictions),
+		Reloads:   atomic.LoadInt64(&fs.reloads),
+	}
+}
+
+// Keys returns a sorted slice of all paths currently in the cache, including cached CGI output keys
+func (fs *FileSystemObject) Keys() []string {
+	keys := cgiOutputKeys()
+
+	// Get cache read lock, defer unlock
+	fs.RLock()
+	defer fs.RUnlock()
+
+	fs.cache.Iterate(func(path string, f *file) {
+		keys = append(keys, path)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Purge removes all cached files with path beginning with supplied prefix (relative to server root if not absolute), returning number removed
+func (fs *FileSystemObject) Purge(prefix string) int {
+	if !path.IsAbs(prefix) {
+		// Join cleans the trailing slash, keep it so "dir/" doesn't also match "dir2/"
+		dirOnly := strings.HasSuffix(prefix, "/")
+		prefix = path.Join(Root, prefix)
+		if dirOnly && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+	}
+	return fs.purgeMatching(func(p string) bool {
+		return strings.HasPrefix(p, prefix)
+	})
+}
+
+// PurgeRegex removes all cached files with path matching supplied regex, returning number removed
+func (fs *FileSystemObject) PurgeRegex(regex *regexp.Regexp) int {
+	return fs.purgeMatching(regex.MatchString)
+}
+
+// purgeMatching removes all cached files and CGI output with path matching supplied function, returning number removed
+func (fs *FileSystemObject) purgeMatching(match func(string) bool) int {
+	purged := purgeCGIOutput(match)
+
+	// Get cache write lock, defer unlock
+	fs.Lock()
+	defer fs.Unlock()
+
+	// Gather matching paths first, we can't remove while iterating
+	toRemove := make([]string, 0)
+	fs.cache.Iterate(func(path string, f *file) {
+		if match(path) {
+			toRemove = append(toRemove, path)
+		}
+	})
+
+	for _, path := range toRemove {
+		fs.cache.Remove(path)
+	}
+	return purged + len(toRemove)
+}
+
+// Reload marks all cached files as unfresh, forcing reload from disk on next access, and drops cached CGI output
+func (fs *FileSystemObject) Reload() {
+	purgeCGIOutput(func(string) bool { return true })
+
+	// Get cache read lock, defer unlock
+	fs.RLock()
+	defer fs.RUnlock()
+
+	fs.cache.Iterate(func(path string, f *file) {
+		f.Lock()
+		f.SetUnfresh()
+		f.Unlock()
+	})
+}
+
 // OpenFile opens a file for reading (read-only, world-readable)
 func (fs *FileSystemObject) OpenFile(p *Path) (*os.File, Error) {
 	fd, err := os.OpenFile(p.Absolute(), os.O_RDONLY, 0444)
@@ -193,7 +311,7 @@ func (fs *FileSystemObject) ScanDirectory(fd *os.File, p *Path, iterator func(os
 	return nil
 }
 
-// AddGeneratedFile adds a generated file content byte slice to the file cache, with supplied path as the key
+// AddGeneratedFile adds a generated file content byte slice to the generated files, with supplied path as the key
 func (fs *FileSystemObject) AddGeneratedFile(p *Path, b []byte) {
 	// Get write lock, defer unlock
 	fs.Lock()
@@ -202,15 +320,35 @@ func (fs *FileSystemObject) AddGeneratedFile(p *Path, b []byte) {
 	// Create new generatedFileContents
 	contents := &generatedFileContents{b}
 
-	// Wrap contents in File
-	file := newFile(contents)
+	// Wrap contents in File, add to generated files
+	fs.generated[p.Absolute()] = newFile(contents)
+}
+
+// AddDynamicFile adds a generated file to the generated files with supplied path as the key, whose contents are regenerated on each request using supplied function
+func (fs *FileSystemObject) AddDynamicFile(p *Path, generate func(*Client, *Path) ([]byte, Error)) {
+	// Get write lock, defer unlock
+	fs.Lock()
+	defer fs.Unlock()
 
-	// Add to cache!
-	fs.cache.Put(p.Absolute(), file)
+	// Create new dynamicFileContents, wrap in File, add to generated files
+	fs.generated[p.Absolute()] = newFile(&dynamicFileContents{generate})
 }
 
-// HandleClient handles a Client, attempting to serve their request from the filesystem whether a regular file, gophermap, dir listing or CGI script
+// HandleClient handles a Client, attempting to serve their request from a registered handler, CGI backend, or the filesystem whether a regular file, gophermap, dir listing or CGI script.
+// Returned Errors carry the request selector as context
 func (fs *FileSystemObject) HandleClient(client *Client, request *Request, newFileContents func(*Path) FileContents, handleDirectory func(*FileSystemObject, *Client, *os.File, *Path) Error) Error {
+	// Record current request, checking whether selector is traced
+	client.setRequest(request)
+	if client.cn.dc.tracer != nil {
+		client.cn.dc.tracer.selector(request.Path())
+	}
+
+	err := fs.handleRequest(client, request, newFileContents, handleDirectory)
+	return WithContext(err, request.Path().Selector())
+}
+
+// handleRequest performs HandleClient for the recorded request
+func (fs *FileSystemObject) handleRequest(client *Client, request *Request, newFileContents func(*Path) FileContents, handleDirectory func(*FileSystemObject, *Client, *os.File, *Path) Error) Error {
 	// If restricted, return error
 	if IsRestrictedPath(request.Path()) {
 		return NewError(RestrictedPathErr)
@@ -222,20 +360,42 @@ func (fs *FileSystemObject) HandleClient(client *Client, request *Request, newFi
 		client.LogInfo(requestRemappedStr, request.Path().Selector(), request.Params())
 	}
 
+	// Apply any per-path throttle override
+	if len(throttlePaths) > 0 {
+		client.cn.dc.throttle = newConnThrottle(throttleRateFor(request.Path()))
+	}
+
+	// Pass to registered handler if selector matches
+	if fn, ok := matchHandler(request.Path()); ok {
+		return fn(client, request)
+	}
+
+	// Pass to CGI backend if selector under a backend prefix
+	if backend, ok := matchBackend(request.Path()); ok {
+		client.cn.dc.startResponse(cgiResponseTimeout)
+		return backend.serve(client, request)
+	}
+
 	// First check for file on disk
 	fd, err := fs.OpenFile(request.Path())
 	if err != nil {
-		// Get read-lock, defer unlock
+		// Don't throw in the towel yet! Check for generated file
 		fs.RLock()
-		defer fs.RUnlock()
-
-		// Don't throw in the towel yet! Check for generated file in cache
-		file, ok := fs.cache.Get(request.Path().Absolute())
+		file, ok := fs.generated[request.Path().Absolute()]
+		fs.RUnlock()
 		if !ok {
+			// Last chance, may be a CGI script followed by path info
+			if WithinCGIDir(request.Path()) && splitCGIPathInfo(request) {
+				if !WithinCGIDir(request.Path()) || IsRestrictedPath(request.Path()) {
+					return NewError(RestrictedPathErr)
+				}
+				client.cn.dc.startResponse(cgiResponseTimeout)
+				return ExecuteCGIScript(client, request)
+			}
 			return err
 		}
 
-		// We got a generated file! Close and send as-is
+		// We got a generated file! Send as-is (generated contents are never modified, so no need to hold lock)
 		return file.WriteToClient(client, request.Path())
 	}
 	defer fd.Close()
@@ -263,6 +423,7 @@ func (fs *FileSystemObject) HandleClient(client *Client, request *Request, newFi
 	case stat.Mode()&os.ModeType == 0:
 		// Execute script if within CGI dir
 		if WithinCGIDir(request.Path()) {
+			client.cn.dc.startResponse(cgiResponseTimeout)
 			return ExecuteCGIScript(client, request)
 		}
 
@@ -289,6 +450,8 @@ func (fs *FileSystemObject) FetchFile(client *Client, fd *os.File, stat os.FileI
 	// Now check for file in cache
 	f, ok := fs.cache.Get(p.Absolute())
 	if !ok {
+		atomic.AddInt64(&fs.misses, 1)
+
 		// Create new file contents with supplied function
 		contents := newFileContents(p)
 
@@ -307,13 +470,18 @@ func (fs *FileSystemObject) FetchFile(client *Client, fd *os.File, stat os.FileI
 		fs.Lock()
 
 		// Put file in cache
-		fs.cache.Put(p.Absolute(), f)
+		if fs.cache.Put(p.Absolute(), f) {
+			atomic.AddInt64(&fs.evictions, 1)
+		}
 
 		// Switch back to cache read lock, get file read lock
 		fs.Unlock()
 		fs.RLock()
 		f.RLock()
 	} else {
+		atomic.AddInt64(&fs.hits, 1)
+		client.cacheHit = true
+
 		// Get file read lock
 		f.RLock()
 
@@ -324,6 +492,7 @@ func (fs *FileSystemObject) FetchFile(client *Client, fd *os.File, stat os.FileI
 			f.Lock()
 
 			// Refresh file contents
+			atomic.AddInt64(&fs.reloads, 1)
 			err := f.CacheContents(fd, p)
 			if err != nil {
 				// Unlock file, return error
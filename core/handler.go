@@ -0,0 +1,48 @@
+package core
+
+import (
+	"regexp"
+	"sync"
+)
+
+// HandlerFunc serves a client request in-process, writing the response using Client.Conn()
+type HandlerFunc func(*Client, *Request) Error
+
+// handler pairs a compiled selector pattern with the HandlerFunc serving matching requests
+type handler struct {
+	pattern *regexp.Regexp
+	fn      HandlerFunc
+}
+
+var (
+	// handlers is the global slice of registered handlers, consulted in order of registration
+	handlers []*handler
+
+	// handlersLock protects handlers
+	handlersLock sync.RWMutex
+)
+
+// Handle registers fn to serve requests whose entire selector matches the regular expression selectorPattern,
+// e.g. "/search" or "/users/[a-z]+". Handlers are consulted in order of registration before the filesystem.
+// Panics if selectorPattern is not a valid regular expression
+func Handle(selectorPattern string, fn HandlerFunc) {
+	pattern := regexp.MustCompile("^(?:" + selectorPattern + ")$")
+
+	handlersLock.Lock()
+	handlers = append(handlers, &handler{pattern, fn})
+	handlersLock.Unlock()
+}
+
+// matchHandler returns the first registered HandlerFunc matching a Path's selector, and success
+func matchHandler(p *Path) (HandlerFunc, bool) {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+
+	sel := p.Selector()
+	for _, h := range handlers {
+		if h.pattern.MatchString(sel) {
+			return h.fn, true
+		}
+	}
+	return nil, false
+}
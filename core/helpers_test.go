@@ -0,0 +1,62 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// setupTestServer sets the server globals needed to handle requests, with a temporary server root
+func setupTestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	Root = dir
+	SystemLog = &logger{sink: &nullSink{}, filter: &levelFilter{}}
+	getRequestPath = getRequestPathUserDirDisabled
+	IsRestrictedPath = isRestrictedPathDisabled
+	RemapRequest = remapRequestDisabled
+	FileSystem = newFileSystemObject(10)
+	cgiOutputCache = newLRUCacheMap(10)
+	connReadDeadline = 5 * time.Second
+	connWriteDeadline = 5 * time.Second
+	maxCGIRunTime = 5 * time.Second
+	maxCGIHeaderSize = 4096
+}
+
+// newTestClient returns a new Client over an in-memory connection, and a function that closes the client's
+// connection and returns everything written to it
+func newTestClient(t *testing.T) (*Client, func() string) {
+	server, remote := net.Pipe()
+	ip := net.ParseIP("127.0.0.1")
+	client := &Client{
+		id:          "test-1",
+		cn:          wrapConn(server),
+		ip:          &ip,
+		port:        "12345",
+		start:       time.Now(),
+		cgiExitCode: -1,
+	}
+
+	output := make(chan []byte, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(remote)
+		output <- b
+	}()
+
+	return client, func() string {
+		client.Conn().Close()
+		select {
+		case b := <-output:
+			return string(b)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out reading client output")
+			return ""
+		}
+	}
+}
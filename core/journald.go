@@ -0,0 +1,96 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Default journald native protocol socket path
+const defaultJournaldPath = "/run/systemd/journal/socket"
+
+// journaldSink implements logSink to write entries using the journald native protocol, with fields as journal fields
+type journaldSink struct {
+	path string
+	name string
+	conn net.Conn
+	sync.Mutex
+}
+
+// newJournaldSink returns a new journaldSink connected to the socket at path, tagging entries with supplied log name, or error
+func newJournaldSink(path, name string) (*journaldSink, error) {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{path: path, name: name, conn: conn}, nil
+}
+
+// writeEntry formats and writes the entry to the journal socket, redialing once on failure (e.g. journald restarted)
+func (s *journaldSink) writeEntry(entry *logEntry) {
+	b := s.format(entry)
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.conn.Write(b)
+	if err == nil {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", s.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, logOutputErrStr+"\n", s.path, err.Error())
+		return
+	}
+	s.conn.Close()
+	s.conn = conn
+	s.conn.Write(b)
+}
+
+// format formats an entry as a journald native protocol datagram. Note entries too large for a single
+// datagram would need passing via memfd, which isn't supported, so such entries are dropped by the kernel
+func (s *journaldSink) format(entry *logEntry) []byte {
+	b := make([]byte, 0, 256)
+	b = appendJournalField(b, "MESSAGE", entry.msg)
+	b = appendJournalField(b, "PRIORITY", fmt.Sprint(syslogSeverity(entry.level)))
+	b = appendJournalField(b, "SYSLOG_IDENTIFIER", "gophor")
+	b = appendJournalField(b, "GOPHOR_LOG", s.name)
+	iterateFields(entry.fields, func(key string, value interface{}) {
+		b = appendJournalField(b, journalFieldName(key), fmt.Sprint(value))
+	})
+	return b
+}
+
+// appendJournalField appends a single journal field to b, using the binary length-prefixed form if value contains a new-line
+func appendJournalField(b []byte, key, value string) []byte {
+	b = append(b, key...)
+	if !strings.Contains(value, "\n") {
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+
+	b = append(b, '\n')
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(value)))
+	b = append(b, size...)
+	b = append(b, value...)
+	return append(b, '\n')
+}
+
+// journalFieldName returns key as a valid journal field name (upper-case letters, digits and underscores, not starting with underscore or digit)
+func journalFieldName(key string) string {
+	name := make([]byte, 0, len(key)+7)
+	name = append(name, "GOPHOR_"...)
+	for _, c := range []byte(strings.ToUpper(key)) {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			c = '_'
+		}
+		name = append(name, c)
+	}
+	return string(name)
+}
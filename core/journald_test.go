@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// parseJournalFields parses a journald native protocol datagram into ordered field names and values
+func parseJournalFields(t *testing.T, b []byte) ([]string, map[string]string) {
+	names, values := []string{}, map[string]string{}
+	for len(b) > 0 {
+		i := bytes.IndexAny(b, "=\n")
+		if i < 0 {
+			t.Fatalf("unterminated field %q", b)
+		}
+		name := string(b[:i])
+
+		var value []byte
+		if b[i] == '=' {
+			b = b[i+1:]
+			end := bytes.IndexByte(b, '\n')
+			if end < 0 {
+				t.Fatalf("unterminated value for %s", name)
+			}
+			value, b = b[:end], b[end+1:]
+		} else {
+			b = b[i+1:]
+			if len(b) < 8 {
+				t.Fatalf("short binary length for %s", name)
+			}
+			size := binary.LittleEndian.Uint64(b[:8])
+			b = b[8:]
+			if uint64(len(b)) < size+1 || b[size] != '\n' {
+				t.Fatalf("bad binary value for %s", name)
+			}
+			value, b = b[:size], b[size+1:]
+		}
+
+		names = append(names, name)
+		values[name] = string(value)
+	}
+	return names, values
+}
+
+func TestJournaldSinkWriteEntry(t *testing.T) {
+	conn, path := listenUnixgram(t)
+	sink, err := newJournaldSink(path, "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink.writeEntry(&logEntry{
+		time:   time.Now(),
+		level:  logLevelInfo,
+		msg:    "line one\nline two",
+		fields: []interface{}{"client", "127.0.0.1", "bytes-out", 42},
+	})
+	names, values := parseJournalFields(t, readDatagram(t, conn))
+
+	want := map[string]string{
+		"MESSAGE":           "line one\nline two",
+		"PRIORITY":          "6",
+		"SYSLOG_IDENTIFIER": "gophor",
+		"GOPHOR_LOG":        "access",
+		"GOPHOR_CLIENT":     "127.0.0.1",
+		"GOPHOR_BYTES_OUT":  "42",
+	}
+	if len(names) != len(want) {
+		t.Errorf("fields = %v, want %d fields", names, len(want))
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+}
+
+func TestAppendJournalField(t *testing.T) {
+	if got := string(appendJournalField(nil, "KEY", "value")); got != "KEY=value\n" {
+		t.Errorf("plain field = %q", got)
+	}
+
+	// Values containing new-lines use name, new-line, 64-bit little endian length, value, new-line
+	got := appendJournalField(nil, "KEY", "a\nb")
+	want := []byte("KEY\n\x03\x00\x00\x00\x00\x00\x00\x00a\nb\n")
+	if !bytes.Equal(got, want) {
+		t.Errorf("binary field = %q, want %q", got, want)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"client":    "GOPHOR_CLIENT",
+		"bytes-out": "GOPHOR_BYTES_OUT",
+		"cache.hit": "GOPHOR_CACHE_HIT",
+		"status2":   "GOPHOR_STATUS2",
+	} {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
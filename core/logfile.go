@@ -0,0 +1,243 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// logRotateSize specifies the size (in bytes) at which log files are rotated, 0 to disable
+	logRotateSize int64
+
+	// logRotateInterval specifies the interval at which log files are rotated, 0 to disable
+	logRotateInterval time.Duration
+
+	// logRotateKeep specifies the number of rotated log files to keep
+	logRotateKeep int
+
+	// logAsyncBufSize specifies the number of log writes that can be queued before dropping, 0 for synchronous writes
+	logAsyncBufSize int
+
+	// logFiles holds all open log file writers by path, so loggers writing to the same path share one
+	logFiles = make(map[string]io.Writer)
+
+	// logFilesLock protects logFiles
+	logFilesLock sync.Mutex
+)
+
+// logFileWriter specifies the extra functions supported by log file writers
+type logFileWriter interface {
+	io.Writer
+	Reopen() error
+	Flush()
+}
+
+// openLogFile returns a log file writer for path, reusing an existing writer if already open
+func openLogFile(path string) (io.Writer, error) {
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	if w, ok := logFiles[path]; ok {
+		return w, nil
+	}
+
+	// Open new log file
+	lf := &logFile{path: path}
+	err := lf.open()
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrap in async writer if enabled
+	var w io.Writer = lf
+	if logAsyncBufSize > 0 {
+		w = newAsyncWriter(lf, logAsyncBufSize)
+	}
+
+	logFiles[path] = w
+	return w, nil
+}
+
+// reopenLogFiles closes and reopens all open log files (e.g. after external rotation)
+func reopenLogFiles() {
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	for path, w := range logFiles {
+		if err := w.(logFileWriter).Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, logOutputErrStr+"\n", path, err.Error())
+		}
+	}
+}
+
+// flushLogFiles waits for all queued log writes to complete
+func flushLogFiles() {
+	logFilesLock.Lock()
+	defer logFilesLock.Unlock()
+
+	for _, w := range logFiles {
+		w.(logFileWriter).Flush()
+	}
+}
+
+// logFile is a log file writer supporting reopening, and size / time based rotation
+type logFile struct {
+	path   string
+	fd     *os.File
+	size   int64
+	opened time.Time
+	sync.Mutex
+}
+
+// open opens the log file for appending, creating if necessary. Any previous file is only closed once the new file
+// is open, so on failure writes continue to the previous file
+func (f *logFile) open() error {
+	fd, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	stat, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+
+	if f.fd != nil {
+		f.fd.Close()
+	}
+	f.fd, f.size, f.opened = fd, stat.Size(), time.Now()
+	return nil
+}
+
+// Write writes to the log file, rotating first if necessary
+func (f *logFile) Write(b []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.shouldRotate(len(b)) {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, logRotateErrStr+"\n", f.path, err.Error())
+		}
+	}
+
+	n, err := f.fd.Write(b)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen reopens the log file, continuing to write to the previous file on failure
+func (f *logFile) Reopen() error {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.open()
+}
+
+// Flush does nothing, writes are synchronous
+func (f *logFile) Flush() {}
+
+// shouldRotate returns whether the log file should be rotated before writing supplied number of bytes
+func (f *logFile) shouldRotate(toWrite int) bool {
+	return (logRotateSize > 0 && f.size > 0 && f.size+int64(toWrite) > logRotateSize) ||
+		(logRotateInterval > 0 && time.Since(f.opened) >= logRotateInterval)
+}
+
+// rotate shifts previously rotated files along (removing the oldest), then reopens the log file. On failure writes
+// continue to the previous (now rotated) file, and rotation is not retried until the next size or interval limit
+func (f *logFile) rotate() error {
+	// Remove oldest, then shift path.N-1 -> path.N ... path -> path.1
+	os.Remove(f.path + "." + strconv.Itoa(logRotateKeep))
+	for i := logRotateKeep - 1; i > 0; i-- {
+		os.Rename(f.path+"."+strconv.Itoa(i), f.path+"."+strconv.Itoa(i+1))
+	}
+	if logRotateKeep > 0 {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+
+	err := f.open()
+	if err != nil {
+		f.size, f.opened = 0, time.Now()
+	}
+	return err
+}
+
+// asyncWrite is a single queued write (or flush request if done is non-nil)
+type asyncWrite struct {
+	b    []byte
+	done chan struct{}
+}
+
+// asyncWriter wraps a log file writer, queueing writes to be performed in a separate goroutine. When the
+// queue is full, writes are dropped rather than blocking the caller
+type asyncWriter struct {
+	dropped int64 // only accessed atomically (kept first for 64-bit alignment)
+	w       logFileWriter
+	queue   chan asyncWrite
+}
+
+// newAsyncWriter returns a new asyncWriter wrapping w with queue of supplied size, and starts the writer goroutine
+func newAsyncWriter(w logFileWriter, size int) *asyncWriter {
+	aw := &asyncWriter{
+		w:     w,
+		queue: make(chan asyncWrite, size),
+	}
+	go aw.run()
+	return aw
+}
+
+// run performs queued writes until the queue is closed
+func (aw *asyncWriter) run() {
+	for write := range aw.queue {
+		// Flush request, signal done
+		if write.done != nil {
+			close(write.done)
+			continue
+		}
+
+		// Note any dropped writes before continuing
+		if dropped := atomic.SwapInt64(&aw.dropped, 0); dropped > 0 {
+			fmt.Fprintf(aw.w, logDroppedStr+"\n", dropped)
+		}
+
+		aw.w.Write(write.b)
+	}
+}
+
+// Write queues a copy of b to be written, dropping it if the queue is full
+func (aw *asyncWriter) Write(b []byte) (int, error) {
+	write := asyncWrite{b: append([]byte(nil), b...)}
+	select {
+	case aw.queue <- write:
+	default:
+		atomic.AddInt64(&aw.dropped, 1)
+	}
+	return len(b), nil
+}
+
+// Reopen reopens the underlying log file writer
+func (aw *asyncWriter) Reopen() error {
+	return aw.w.Reopen()
+}
+
+// Flush blocks until all writes queued before calling have been performed (or one second passes)
+func (aw *asyncWriter) Flush() {
+	done := make(chan struct{})
+	select {
+	case aw.queue <- asyncWrite{done: done}:
+	case <-time.After(time.Second):
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
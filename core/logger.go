@@ -1,8 +1,16 @@
 package core
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -13,80 +21,337 @@ var (
 	SystemLog loggerInterface
 )
 
-func setupLogger(output string) loggerInterface {
-	switch output {
-	case "stdout":
-		return &stdLogger{}
-	case "null":
-		return &nullLogger{}
+// logLevel specifies the minimum level of messages a logger outputs
+type logLevel int32
+
+// Supported log levels
+const (
+	logLevelDebug logLevel = 0
+	logLevelInfo  logLevel = 1
+	logLevelWarn  logLevel = 2
+	logLevelError logLevel = 3
+	logLevelFatal logLevel = 4
+	logLevelNone  logLevel = 5
+)
+
+// parseLogLevel parses a log level string, returning level and success
+func parseLogLevel(level string) (logLevel, bool) {
+	switch level {
+	case "debug":
+		return logLevelDebug, true
+	case "info":
+		return logLevelInfo, true
+	case "warn":
+		return logLevelWarn, true
+	case "error":
+		return logLevelError, true
+	case "none":
+		return logLevelNone, true
+	default:
+		return 0, false
+	}
+}
+
+// String returns the log level string
+func (level logLevel) String() string {
+	switch level {
+	case logLevelDebug:
+		return "debug"
+	case logLevelInfo:
+		return "info"
+	case logLevelWarn:
+		return "warn"
+	case logLevelError:
+		return "error"
+	case logLevelFatal:
+		return "fatal"
+	default:
+		return "none"
+	}
+}
+
+// prefix returns the single character log level prefix used in text output
+func (level logLevel) prefix() string {
+	switch level {
+	case logLevelDebug:
+		return "D"
+	case logLevelInfo:
+		return "I"
+	case logLevelWarn:
+		return "W"
+	case logLevelError:
+		return "E"
+	default:
+		return "F"
+	}
+}
+
+// logFormat specifies the output format of a stream log sink
+type logFormat int
+
+// Supported log formats
+const (
+	logFormatText logFormat = 0
+	logFormatJSON logFormat = 1
+	logFormatRaw  logFormat = 2 // message only, used internally for pre-formatted access records
+)
+
+// parseLogFormat parses a log format string, returning format and success
+func parseLogFormat(format string) (logFormat, bool) {
+	switch format {
+	case "text":
+		return logFormatText, true
+	case "json":
+		return logFormatJSON, true
+	default:
+		return 0, false
+	}
+}
+
+// isStructuredLogOutput returns whether a log output location stores entry fields natively
+func isStructuredLogOutput(output string) bool {
+	kind, _ := splitBy(output, ":")
+	return kind == "syslog" || kind == "journald"
+}
+
+// setupLogger returns a new named logger writing to supplied output location, in supplied format, at supplied level
+func setupLogger(name, output string, format logFormat, level logLevel) loggerInterface {
+	// Split output into kind and optional socket path (for syslog and journald)
+	kind, socketPath := splitBy(output, ":")
+
+	var sink logSink
+	switch {
+	case output == "stdout":
+		sink = &streamSink{w: os.Stdout, format: format}
+	case output == "null":
+		sink = &nullSink{}
+	case kind == "syslog":
+		if socketPath == "" {
+			socketPath = defaultSyslogPath
+		}
+		var err error
+		sink, err = newSyslogSink(socketPath, name)
+		if err != nil {
+			log.Fatalf(logOutputErrStr, output, err.Error())
+		}
+	case kind == "journald":
+		if socketPath == "" {
+			socketPath = defaultJournaldPath
+		}
+		var err error
+		sink, err = newJournaldSink(socketPath, name)
+		if err != nil {
+			log.Fatalf(logOutputErrStr, output, err.Error())
+		}
 	default:
-		fd, err := os.OpenFile(output, os.O_CREATE|os.O_APPEND, 0600)
+		w, err := openLogFile(output)
 		if err != nil {
 			log.Fatalf(logOutputErrStr, output, err.Error())
 		}
-		return &logger{log.New(fd, "", log.LstdFlags)}
+		sink = &streamSink{w: w, format: format}
 	}
+
+	l := &logger{sink: sink, filter: &levelFilter{}}
+	l.SetLevel(level)
+	return l
 }
 
-// LoggerInterface specifies an interface that can log different message levels
+// LoggerInterface specifies an interface that can log different message levels, with optional key/value fields
 type loggerInterface interface {
+	Debug(string, ...interface{})
 	Info(string, ...interface{})
+	Warn(string, ...interface{})
 	Error(string, ...interface{})
 	Fatal(string, ...interface{})
+	With(...interface{}) loggerInterface
+	Level() logLevel
+	SetLevel(logLevel)
 }
 
-// StdLogger implements LoggerInterface to log to output using regular log
-type stdLogger struct{}
+// levelFilter provides the level setting for LoggerInterface implementations, safe for concurrent use
+type levelFilter struct {
+	level int32
+}
+
+// Level returns the currently set log level
+func (f *levelFilter) Level() logLevel {
+	return logLevel(atomic.LoadInt32(&f.level))
+}
 
-// Info logs to log.Logger with info level prefix
-func (l *stdLogger) Info(fmt string, args ...interface{}) {
-	log.Printf(":: I :: "+fmt, args...)
+// SetLevel sets the log level
+func (f *levelFilter) SetLevel(level logLevel) {
+	atomic.StoreInt32(&f.level, int32(level))
 }
 
-// Error logs to log.Logger with error level prefix
-func (l *stdLogger) Error(fmt string, args ...interface{}) {
-	log.Printf(":: E :: "+fmt, args...)
+// enabled returns whether messages at level should be logged
+func (f *levelFilter) enabled(level logLevel) bool {
+	return level >= f.Level()
 }
 
-// Fatal logs to standard log with fatal prefix and terminates program
-func (l *stdLogger) Fatal(fmt string, args ...interface{}) {
-	log.Fatalf(":: F :: "+fmt, args...)
+// logEntry holds a single structured log record
+type logEntry struct {
+	time   time.Time
+	level  logLevel
+	msg    string
+	fields []interface{}
 }
 
-// logger implements LoggerInterface to log to output using underlying log.Logger
+// logSink specifies an interface that outputs log entries
+type logSink interface {
+	writeEntry(*logEntry)
+}
+
+// logger implements LoggerInterface, passing entries with attached key/value fields to a log sink
 type logger struct {
-	logger *log.Logger
+	sink   logSink
+	filter *levelFilter // shared between a logger and those derived using With()
+	fields []interface{}
+}
+
+// Debug logs at debug level
+func (l *logger) Debug(fmt string, args ...interface{}) {
+	l.log(logLevelDebug, fmt, args)
 }
 
-// Info logs to log.Logger with info level prefix
+// Info logs at info level
 func (l *logger) Info(fmt string, args ...interface{}) {
-	l.logger.Printf("I :: "+fmt, args...)
+	l.log(logLevelInfo, fmt, args)
+}
+
+// Warn logs at warn level
+func (l *logger) Warn(fmt string, args ...interface{}) {
+	l.log(logLevelWarn, fmt, args)
 }
 
-// Error logs to log.Logger with error level prefix
+// Error logs at error level
 func (l *logger) Error(fmt string, args ...interface{}) {
-	l.logger.Printf("E :: "+fmt, args...)
+	l.log(logLevelError, fmt, args)
 }
 
-// Fatal logs to log.Logger with fatal prefix and terminates program
+// Fatal logs at fatal level (regardless of set level), flushes log files and terminates program
 func (l *logger) Fatal(fmt string, args ...interface{}) {
-	l.logger.Fatalf("F :: "+fmt, args...)
+	l.sink.writeEntry(l.newEntry(logLevelFatal, fmt, args))
+	flushLogFiles()
+	os.Exit(1)
 }
 
-// nullLogger implements LoggerInterface to do absolutely fuck-all
-type nullLogger struct{}
+// With returns a new logger sharing output and level, with supplied key/value pairs attached to every entry
+func (l *logger) With(kv ...interface{}) loggerInterface {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &logger{l.sink, l.filter, fields}
+}
 
-// Info does nothing
-func (l *nullLogger) Info(fmt string, args ...interface{}) {
-	// do nothing
+// Level returns the currently set log level
+func (l *logger) Level() logLevel {
+	return l.filter.Level()
 }
 
-// Error does nothing
-func (l *nullLogger) Error(fmt string, args ...interface{}) {
-	// do nothing
+// SetLevel sets the log level
+func (l *logger) SetLevel(level logLevel) {
+	l.filter.SetLevel(level)
 }
 
-// Fatal simply terminates the program
-func (l *nullLogger) Fatal(fmt string, args ...interface{}) {
-	os.Exit(1)
+// log passes a new entry to the sink if level enabled
+func (l *logger) log(level logLevel, fmt string, args []interface{}) {
+	if l.filter.enabled(level) {
+		l.sink.writeEntry(l.newEntry(level, fmt, args))
+	}
+}
+
+// newEntry returns a new log entry with formatted message and the logger's fields
+func (l *logger) newEntry(level logLevel, format string, args []interface{}) *logEntry {
+	return &logEntry{time.Now(), level, fmt.Sprintf(format, args...), l.fields}
+}
+
+// streamSink implements logSink to write formatted entries to an io.Writer
+type streamSink struct {
+	w      io.Writer
+	format logFormat
+	sync.Mutex
+}
+
+// writeEntry formats and writes the entry to the underlying writer
+func (s *streamSink) writeEntry(entry *logEntry) {
+	var b []byte
+	switch s.format {
+	case logFormatJSON:
+		b = formatEntryJSON(entry)
+	case logFormatRaw:
+		b = append([]byte(entry.msg), '\n')
+	default:
+		b = formatEntryText(entry)
+	}
+
+	s.Lock()
+	s.w.Write(b)
+	s.Unlock()
+}
+
+// nullSink implements logSink to do absolutely fuck-all
+type nullSink struct{}
+
+// writeEntry does nothing
+func (s *nullSink) writeEntry(entry *logEntry) {}
+
+// formatEntryText formats an entry as a single line of text, with fields as trailing key=value pairs
+func formatEntryText(entry *logEntry) []byte {
+	b := make([]byte, 0, 128)
+	b = entry.time.AppendFormat(b, "2006/01/02 15:04:05")
+	b = append(b, " :: "+entry.level.prefix()+" :: "...)
+	b = append(b, entry.msg...)
+
+	iterateFields(entry.fields, func(key string, value interface{}) {
+		str := fmt.Sprint(value)
+		if str == "" || strings.ContainsAny(str, " =\"") {
+			str = strconv.Quote(str)
+		}
+		b = append(b, ' ')
+		b = append(b, key...)
+		b = append(b, '=')
+		b = append(b, str...)
+	})
+
+	return append(b, '\n')
+}
+
+// formatEntryJSON formats an entry as a single line JSON object, with fields as additional members
+func formatEntryJSON(entry *logEntry) []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, `{"time":"`...)
+	b = entry.time.AppendFormat(b, time.RFC3339Nano)
+	b = append(b, `","level":"`...)
+	b = append(b, entry.level.String()...)
+	b = append(b, `","msg":`...)
+	b = appendJSON(b, entry.msg)
+
+	iterateFields(entry.fields, func(key string, value interface{}) {
+		b = append(b, ',')
+		b = appendJSON(b, key)
+		b = append(b, ':')
+		b = appendJSON(b, value)
+	})
+
+	return append(b, '}', '\n')
+}
+
+// appendJSON appends the JSON encoding of value to b, falling back to a string encoding on failure
+func appendJSON(b []byte, value interface{}) []byte {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+
+	enc, err := json.Marshal(value)
+	if err != nil {
+		enc, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return append(b, enc...)
+}
+
+// iterateFields performs an iteration over key/value pairs in a fields slice
+func iterateFields(fields []interface{}, iterator func(string, interface{})) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		iterator(fmt.Sprint(fields[i]), fields[i+1])
+	}
 }
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// metricsListener holds the global metrics listener (nil if disabled)
+	metricsListener net.Listener
+
+	// defaultBuckets are the histogram buckets (in seconds) used for duration metrics
+	defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// requestsTotal counts requests by outcome and ErrorCode
+	requestsTotal = newCounterVec()
+
+	// bytesSentTotal counts bytes written to clients
+	bytesSentTotal = &counter{}
+
+	// requestDuration observes total request durations
+	requestDuration = newHistogram(defaultBuckets)
+
+	// cgiExecutionsTotal counts CGI script executions
+	cgiExecutionsTotal = &counter{}
+
+	// cgiTimeoutsTotal counts CGI scripts killed for exceeding max run time
+	cgiTimeoutsTotal = &counter{}
+
+	// cgiDuration observes CGI script execution durations
+	cgiDuration = newHistogram(defaultBuckets)
+)
+
+// counter is a monotonically increasing metric, safe for concurrent use
+type counter struct {
+	value int64
+}
+
+// Add adds delta to the counter
+func (c *counter) Add(delta int64) {
+	atomic.AddInt64(&c.value, delta)
+}
+
+// Inc increments the counter by 1
+func (c *counter) Inc() {
+	c.Add(1)
+}
+
+// Value returns the current counter value
+func (c *counter) Value() int64 {
+	return atomic.LoadInt64(&c.value)
+}
+
+// counterVec is a set of counters keyed by label values, safe for concurrent use
+type counterVec struct {
+	counters map[[2]string]*counter
+	sync.Mutex
+}
+
+// newCounterVec returns a new empty counterVec
+func newCounterVec() *counterVec {
+	return &counterVec{
+		make(map[[2]string]*counter),
+		sync.Mutex{},
+	}
+}
+
+// With returns the counter for the supplied pair of label values, creating if necessary
+func (v *counterVec) With(label1, label2 string) *counter {
+	v.Lock()
+	defer v.Unlock()
+
+	key := [2]string{label1, label2}
+	c, ok := v.counters[key]
+	if !ok {
+		c = &counter{}
+		v.counters[key] = c
+	}
+	return c
+}
+
+// Iterate performs an iteration over all label values and counter values in counterVec in sorted order
+func (v *counterVec) Iterate(iterator func(label1, label2 string, value int64)) {
+	v.Lock()
+	keys := make([][2]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	v.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		iterator(key[0], key[1], v.With(key[0], key[1]).Value())
+	}
+}
+
+// histogram counts observed durations into cumulative buckets, safe for concurrent use
+type histogram struct {
+	buckets []float64
+	counts  []int64
+	count   int64
+	sum     float64
+	sync.Mutex
+}
+
+// newHistogram returns a new histogram with supplied (sorted) bucket upper bounds
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]int64, len(buckets)),
+	}
+}
+
+// Observe adds a duration observation to the histogram
+func (h *histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+
+	h.Lock()
+	for i, bound := range h.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+	h.Unlock()
+}
+
+// writeTo writes the histogram in Prometheus text exposition format under supplied metric name
+func (h *histogram) writeTo(w io.Writer, name string) {
+	h.Lock()
+	defer h.Unlock()
+
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// recordRequest records request metrics for a finished request, with supplied final Error status
+func recordRequest(client *Client, err Error) {
+	if err != nil {
+		requestsTotal.With("error", strconv.Itoa(int(err.Code()))).Inc()
+		recordRecentError(client, err)
+	} else {
+		requestsTotal.With("served", "0").Inc()
+	}
+	requestDuration.Observe(client.Duration())
+}
+
+// newMetricsListener returns a new TCP listener for the metrics endpoint or Error
+func newMetricsListener(addr string) (net.Listener, Error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, WrapError(MetricsListenErr, err)
+	}
+	return l, nil
+}
+
+// serveMetrics serves the metrics endpoint on the metrics listener
+func serveMetrics() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+
+	err := http.Serve(metricsListener, mux)
+	SystemLog.Error(metricsServeErrStr, err.Error())
+}
+
+// writeMetricHeader writes the HELP and TYPE lines for a metric
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// writeMetrics writes all metrics in Prometheus text exposition format
+func writeMetrics(w io.Writer) {
+	writeMetricHeader(w, "gophor_requests_total", "Total requests by outcome and error code.", "counter")
+	requestsTotal.Iterate(func(outcome, code string, value int64) {
+		fmt.Fprintf(w, "gophor_requests_total{outcome=\"%s\",code=\"%s\"} %d\n", outcome, code, value)
+	})
+
+	writeMetricHeader(w, "gophor_request_duration_seconds", "Request duration in seconds.", "histogram")
+	requestDuration.writeTo(w, "gophor_request_duration_seconds")
+
+	writeMetricHeader(w, "gophor_bytes_sent_total", "Total bytes sent to clients.", "counter")
+	fmt.Fprintf(w, "gophor_bytes_sent_total %d\n", bytesSentTotal.Value())
+
+	writeMetricHeader(w, "gophor_active_connections", "Currently active client connections.", "gauge")
+	fmt.Fprintf(w, "gophor_active_connections %d\n", len(ActiveClients()))
+
+	writeMetricHeader(w, "gophor_accept_queue_depth", "Accepted connections waiting for a free worker.", "gauge")
+	fmt.Fprintf(w, "gophor_accept_queue_depth %d\n", acceptQueueDepth())
+	writeMetricHeader(w, "gophor_accept_queue_capacity", "Accept queue capacity (0 if worker pool disabled).", "gauge")
+	fmt.Fprintf(w, "gophor_accept_queue_capacity %d\n", cap(acceptQueue))
+	writeMetricHeader(w, "gophor_accept_queue_rejected_total", "Total connections rejected due to a full accept queue.", "counter")
+	fmt.Fprintf(w, "gophor_accept_queue_rejected_total %d\n", acceptQueueRejectedTotal.Value())
+	writeMetricHeader(w, "gophor_workers_busy", "Workers currently serving a connection.", "gauge")
+	fmt.Fprintf(w, "gophor_workers_busy %d\n", atomic.LoadInt64(&busyWorkers))
+
+	stats := FileSystem.Stats()
+	writeMetricHeader(w, "gophor_cache_entries", "Current file cache entries.", "gauge")
+	fmt.Fprintf(w, "gophor_cache_entries %d\n", stats.Entries)
+	writeMetricHeader(w, "gophor_cache_bytes", "Current file cache size in bytes.", "gauge")
+	fmt.Fprintf(w, "gophor_cache_bytes %d\n", stats.Bytes)
+	writeMetricHeader(w, "gophor_cache_hits_total", "Total file cache hits.", "counter")
+	fmt.Fprintf(w, "gophor_cache_hits_total %d\n", stats.Hits)
+	writeMetricHeader(w, "gophor_cache_misses_total", "Total file cache misses.", "counter")
+	fmt.Fprintf(w, "gophor_cache_misses_total %d\n", stats.Misses)
+	writeMetricHeader(w, "gophor_cache_evictions_total", "Total file cache evictions.", "counter")
+	fmt.Fprintf(w, "gophor_cache_evictions_total %d\n", stats.Evictions)
+	writeMetricHeader(w, "gophor_cache_reloads_total", "Total file cache reloads.", "counter")
+	fmt.Fprintf(w, "gophor_cache_reloads_total %d\n", stats.Reloads)
+
+	writeMetricHeader(w, "gophor_cgi_executions_total", "Total CGI script executions.", "counter")
+	fmt.Fprintf(w, "gophor_cgi_executions_total %d\n", cgiExecutionsTotal.Value())
+	writeMetricHeader(w, "gophor_cgi_timeouts_total", "Total CGI scripts killed for exceeding max run time.", "counter")
+	fmt.Fprintf(w, "gophor_cgi_timeouts_total %d\n", cgiTimeoutsTotal.Value())
+	writeMetricHeader(w, "gophor_cgi_rejected_total", "Total CGI executions rejected due to concurrency limits.", "counter")
+	fmt.Fprintf(w, "gophor_cgi_rejected_total %d\n", cgiRejectedTotal.Value())
+	writeMetricHeader(w, "gophor_cgi_duration_seconds", "CGI script execution duration in seconds.", "histogram")
+	cgiDuration.writeTo(w, "gophor_cgi_duration_seconds")
+}
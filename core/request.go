@@ -2,8 +2,9 @@ package core
 
 // Request is a data structure for storing a filesystem path, and params, parsed from a client's request
 type Request struct {
-	p      *Path
-	params string
+	p        *Path
+	params   string
+	pathInfo string // trailing path after a CGI script, empty if none
 }
 
 // Path returns the requests associate Path object
@@ -16,6 +17,11 @@ func (r *Request) Params() string {
 	return r.params
 }
 
+// PathInfo returns the trailing path following a CGI script in the request selector, or empty string
+func (r *Request) PathInfo() string {
+	return r.pathInfo
+}
+
 // Remap modifies a request to use new relative path, and accommodate supplied extra parameters
 func (r *Request) Remap(rel, params string) {
 	if len(r.params) > 0 {
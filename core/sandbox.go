@@ -0,0 +1,208 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// cgiTrampolineArg0 is the argv[0] used when re-executing ourselves to apply the CGI sandbox before executing a script.
+// syscall.SysProcAttr has no field for resource limits, and setting them on the server before forking would race with
+// every other goroutine, so sandboxed scripts are started via a copy of ourselves that applies the limits then execs
+// the script. That copy runs with the script's credential, so must be readable and executable by the CGI users
+const cgiTrampolineArg0 = "gophor-cgi-trampoline"
+
+var (
+	// cgiSandboxConfig holds the sandbox applied to CGI scripts (nil if disabled)
+	cgiSandboxConfig *cgiSandbox
+
+	// cgiCredential holds the user and group to run CGI scripts as (nil to run as server user)
+	cgiCredential *syscall.Credential
+
+	// selfExecutable holds the path to our own executable, used for the CGI trampoline
+	selfExecutable string
+
+	// cgiSlots limits the number of concurrently running CGI scripts (nil if unlimited)
+	cgiSlots chan struct{}
+
+	// maxCGIProcsPerScript specifies the max number of concurrently running instances of each CGI script, 0 for unlimited
+	maxCGIProcsPerScript int
+
+	// cgiScriptSlots holds per-script concurrency limits by absolute path
+	cgiScriptSlots = make(map[string]chan struct{})
+
+	// cgiScriptSlotsLock protects cgiScriptSlots
+	cgiScriptSlotsLock sync.Mutex
+
+	// cgiRejectedTotal counts CGI executions rejected due to concurrency limits
+	cgiRejectedTotal = &counter{}
+)
+
+// RunCGITrampoline checks whether we were executed as the CGI trampoline, if so applying the sandbox and executing the
+// script, never returning. Must be called first thing in main, before any flag parsing or server setup
+func RunCGITrampoline() {
+	if len(os.Args) == 3 && os.Args[0] == cgiTrampolineArg0 {
+		execSandboxed(os.Args[1], os.Args[2])
+	}
+}
+
+// cgiSandbox holds resource limits (0 to leave unchanged) and restrictions applied to CGI scripts
+type cgiSandbox struct {
+	cpu        uint64 // seconds
+	as         uint64 // bytes
+	nofile     uint64
+	nproc      uint64
+	noNewPrivs bool
+}
+
+// enabled returns whether any part of the sandbox is set
+func (s *cgiSandbox) enabled() bool {
+	return s.cpu > 0 || s.as > 0 || s.nofile > 0 || s.nproc > 0 || s.noNewPrivs
+}
+
+// String encodes the sandbox for passing to the CGI trampoline
+func (s *cgiSandbox) String() string {
+	return fmt.Sprintf("%d,%d,%d,%d,%t", s.cpu, s.as, s.nofile, s.nproc, s.noNewPrivs)
+}
+
+// parseCGISandbox decodes a sandbox encoded by String()
+func parseCGISandbox(str string) (*cgiSandbox, error) {
+	split := strings.Split(str, ",")
+	if len(split) != 5 {
+		return nil, fmt.Errorf("invalid sandbox: %q", str)
+	}
+
+	values := make([]uint64, 4)
+	for i := range values {
+		value, err := strconv.ParseUint(split[i], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	noNewPrivs, err := strconv.ParseBool(split[4])
+	if err != nil {
+		return nil, err
+	}
+
+	return &cgiSandbox{values[0], values[1], values[2], values[3], noNewPrivs}, nil
+}
+
+// execSandboxed applies the encoded sandbox to the current process then replaces it with the script.
+// Failures are written to stderr (captured by the server's CGI stderr logger)
+func execSandboxed(sandbox, script string) {
+	s, err := parseCGISandbox(sandbox)
+	if err == nil {
+		err = applyCGISandbox(s)
+	}
+	if err == nil {
+		err = syscall.Exec(script, []string{script}, os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, cgiTrampolineErrStr+"\n", script, err.Error())
+	os.Exit(127)
+}
+
+// setupCGISandbox sets the CGI sandbox and credential from supplied flag values (limits of 0 and ids of -1 being disabled)
+func setupCGISandbox(cpu, as, nofile, nproc uint64, noNewPrivs bool, uid, gid int) {
+	sandbox := &cgiSandbox{cpu, as, nofile, nproc, noNewPrivs}
+	if sandbox.enabled() {
+		if !cgiSandboxSupported {
+			SystemLog.Fatal(cgiSandboxUnsupportedStr)
+		}
+
+		exe, err := os.Executable()
+		if err != nil {
+			SystemLog.Fatal(cgiSandboxExecutableErrStr, err.Error())
+		}
+		selfExecutable = exe
+		cgiSandboxConfig = sandbox
+		SystemLog.Info(cgiSandboxEnabledStr, sandbox.cpu, sandbox.as, sandbox.nofile, sandbox.nproc, sandbox.noNewPrivs)
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if uid < 0 {
+			uid = os.Getuid()
+		}
+		if gid < 0 {
+			gid = os.Getgid()
+		}
+		cgiCredential = newCGICredential(uint32(uid), uint32(gid))
+		if cgiCredential != nil {
+			SystemLog.Info(cgiCredentialStr, uid, gid)
+		}
+	}
+
+	// The trampoline runs as the CGI credential, or as any local user if user CGI is setuid
+	if cgiSandboxConfig != nil {
+		if cgiCredential != nil && !executableBy(selfExecutable, int(cgiCredential.Uid), int(cgiCredential.Gid)) {
+			SystemLog.Fatal(cgiTrampolineNotExecutableStr, selfExecutable, "uid "+strconv.Itoa(int(cgiCredential.Uid)))
+		}
+		if userCGIEnabled && userCGISetuid && !executableBy(selfExecutable, -1, -1) {
+			SystemLog.Fatal(cgiTrampolineNotExecutableStr, selfExecutable, "all users")
+		}
+	}
+}
+
+// newCGICredential returns the credential to run CGI scripts as uid and gid, or nil if that is already our identity.
+// Supplementary groups are only dropped when running as root, as setgroups fails with EPERM otherwise
+func newCGICredential(uid, gid uint32) *syscall.Credential {
+	if int(uid) == os.Getuid() && int(gid) == os.Getgid() {
+		return nil
+	}
+	return &syscall.Credential{Uid: uid, Gid: gid, Groups: []uint32{}, NoSetGroups: os.Getuid() != 0}
+}
+
+// acquireCGISlot reserves a slot to run the script at path within the global and per-script concurrency limits,
+// returning a release function and success
+func acquireCGISlot(path string) (func(), bool) {
+	// Get per-script slots, creating if necessary
+	var scriptSlots chan struct{}
+	if maxCGIProcsPerScript > 0 {
+		cgiScriptSlotsLock.Lock()
+		scriptSlots = cgiScriptSlots[path]
+		if scriptSlots == nil {
+			scriptSlots = make(chan struct{}, maxCGIProcsPerScript)
+			cgiScriptSlots[path] = scriptSlots
+		}
+		cgiScriptSlotsLock.Unlock()
+	}
+
+	// Try reserve global then per-script slot
+	if !tryAcquire(cgiSlots) {
+		cgiRejectedTotal.Inc()
+		return nil, false
+	}
+	if !tryAcquire(scriptSlots) {
+		release(cgiSlots)
+		cgiRejectedTotal.Inc()
+		return nil, false
+	}
+
+	return func() {
+		release(scriptSlots)
+		release(cgiSlots)
+	}, true
+}
+
+// tryAcquire attempts to reserve a slot in a semaphore without blocking, always succeeding if nil
+func tryAcquire(slots chan struct{}) bool {
+	if slots == nil {
+		return true
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees a slot in a semaphore, doing nothing if nil
+func release(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// cgiSandboxSupported specifies whether CGI sandboxing is supported on this platform
+const cgiSandboxSupported = true
+
+// Linux constants not provided by the syscall package
+const (
+	rlimitNproc     = 6
+	prSetNoNewPrivs = 38
+)
+
+// applyCGISandbox applies resource limits and no-new-privs to the current process
+func applyCGISandbox(s *cgiSandbox) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_CPU, s.cpu},
+		{syscall.RLIMIT_AS, s.as},
+		{syscall.RLIMIT_NOFILE, s.nofile},
+		{rlimitNproc, s.nproc},
+	}
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		err := syscall.Setrlimit(limit.resource, &syscall.Rlimit{Cur: limit.value, Max: limit.value})
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.noNewPrivs {
+		_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0)
+		if errno != 0 {
+			return errno
+		}
+	}
+
+	return nil
+}
+
+// executableBy returns whether the file at path is readable and executable by uid and gid, including search permission
+// on each parent directory. A uid and gid of -1 check permission for any other user
+func executableBy(path string, uid, gid int) bool {
+	if uid == 0 {
+		return true
+	}
+
+	mode := uint32(05) // read + execute
+	for {
+		info, err := os.Stat(path)
+		if err != nil {
+			return false
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false
+		}
+
+		// Check owner, else group, else other permission bits
+		perm := stat.Mode & 07
+		switch {
+		case uid >= 0 && int(stat.Uid) == uid:
+			perm = (stat.Mode >> 6) & 07
+		case gid >= 0 && int(stat.Gid) == gid:
+			perm = (stat.Mode >> 3) & 07
+		}
+		if perm&mode != mode {
+			return false
+		}
+
+		// Parent directories only need search permission
+		parent := filepath.Dir(path)
+		if parent == path {
+			return true
+		}
+		path, mode = parent, 01
+	}
+}
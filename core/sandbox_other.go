@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package core
+
+import "errors"
+
+// cgiSandboxSupported specifies whether CGI sandboxing is supported on this platform
+const cgiSandboxSupported = false
+
+// applyCGISandbox is unsupported on this platform
+func applyCGISandbox(s *cgiSandbox) error {
+	return errors.New(cgiSandboxUnsupportedStr)
+}
+
+// executableBy is never needed on this platform, as the CGI sandbox is unsupported
+func executableBy(path string, uid, gid int) bool {
+	return true
+}
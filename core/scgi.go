@@ -0,0 +1,46 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+)
+
+// scgiEncodeHeaders encodes a CGI environment as an SCGI netstring of null-terminated headers,
+// CONTENT_LENGTH first and SCGI=1 as required by the protocol
+func scgiEncodeHeaders(env []string) []byte {
+	headers := bytes.NewBuffer(make([]byte, 0, 1024))
+	headers.WriteString("CONTENT_LENGTH\x000\x00SCGI\x001\x00")
+	for _, kv := range env {
+		name, value := splitBy(kv, "=")
+		if name == "CONTENT_LENGTH" || name == "SCGI" {
+			continue
+		}
+		headers.WriteString(name)
+		headers.WriteByte(0)
+		headers.WriteString(value)
+		headers.WriteByte(0)
+	}
+
+	netstring := make([]byte, 0, headers.Len()+16)
+	netstring = strconv.AppendInt(netstring, int64(headers.Len()), 10)
+	netstring = append(netstring, ':')
+	netstring = append(netstring, headers.Bytes()...)
+	return append(netstring, ',')
+}
+
+// serveSCGI implements backendProtocol for SCGI applications. SCGI has no stderr or exit status, the response
+// being the remaining connection output
+func serveSCGI(conn net.Conn, env []string, stdout, stderr io.Writer) (int, error) {
+	// Send request headers, no body
+	if _, err := conn.Write(scgiEncodeHeaders(env)); err != nil {
+		return -1, err
+	}
+
+	// Copy response until backend closes connection
+	if _, err := io.Copy(stdout, conn); err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
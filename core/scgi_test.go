@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scgiTestDecodeNetstring decodes an SCGI request netstring into ordered header names and values, checking the length prefix
+func scgiTestDecodeNetstring(t *testing.T, b []byte) ([]string, map[string]string) {
+	colon := bytes.IndexByte(b, ':')
+	if colon < 0 {
+		t.Fatalf("no length prefix in %q", b)
+	}
+	length, err := strconv.Atoi(string(b[:colon]))
+	if err != nil {
+		t.Fatalf("invalid length prefix %q", b[:colon])
+	}
+	if len(b) != colon+1+length+1 || b[len(b)-1] != ',' {
+		t.Fatalf("length prefix %d doesn't match %d byte netstring body", length, len(b)-colon-2)
+	}
+
+	fields := bytes.Split(b[colon+1:colon+1+length], []byte{0})
+	if len(fields[len(fields)-1]) != 0 || len(fields)%2 != 1 {
+		t.Fatalf("headers not null-terminated name-value pairs")
+	}
+
+	names, values := []string{}, map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		names = append(names, string(fields[i]))
+		values[string(fields[i])] = string(fields[i+1])
+	}
+	return names, values
+}
+
+func TestSCGIEncodeHeaders(t *testing.T) {
+	env := []string{
+		"SERVER_NAME=localhost",
+		"CONTENT_LENGTH=12",
+		"QUERY_STRING=a=b",
+		"SCGI=2",
+		"EMPTY=",
+	}
+	names, values := scgiTestDecodeNetstring(t, scgiEncodeHeaders(env))
+
+	// CONTENT_LENGTH must be first, then SCGI, overriding any values in env
+	want := []string{"CONTENT_LENGTH", "SCGI", "SERVER_NAME", "QUERY_STRING", "EMPTY"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("header order %v, want %v", names, want)
+	}
+	for name, value := range map[string]string{
+		"CONTENT_LENGTH": "0",
+		"SCGI":           "1",
+		"SERVER_NAME":    "localhost",
+		"QUERY_STRING":   "a=b",
+		"EMPTY":          "",
+	} {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+}
+
+func TestSCGIEncodeHeadersEmpty(t *testing.T) {
+	want := "24:CONTENT_LENGTH\x000\x00SCGI\x001\x00,"
+	if got := string(scgiEncodeHeaders(nil)); got != want {
+		t.Errorf("scgiEncodeHeaders(nil) = %q, want %q", got, want)
+	}
+}
+
+func TestServeSCGI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gophor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scgi.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// Read netstring request, respond then close
+	requests := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		prefix, err := r.ReadString(':')
+		if err != nil {
+			return
+		}
+		length, _ := strconv.Atoi(strings.TrimSuffix(prefix, ":"))
+		body := make([]byte, length+1)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return
+		}
+		requests <- append([]byte(prefix), body...)
+		conn.Write([]byte("Content-Type: text/plain\r\n\r\nhello"))
+	}()
+
+	stdout := &bytes.Buffer{}
+	status, err := serveSCGI(dialTest(t, path), []string{"QUERY_STRING=q"}, stdout, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 0 {
+		t.Errorf("status = %d, want 0", status)
+	}
+	if got := stdout.String(); got != "Content-Type: text/plain\r\n\r\nhello" {
+		t.Errorf("stdout = %q", got)
+	}
+	if _, values := scgiTestDecodeNetstring(t, <-requests); values["QUERY_STRING"] != "q" {
+		t.Errorf("QUERY_STRING = %q, want %q", values["QUERY_STRING"], "q")
+	}
+}
@@ -3,11 +3,14 @@ package core
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"path"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -20,15 +23,36 @@ const (
 var (
 	// SigChannel is the global OS signal channel
 	sigChannel chan os.Signal
+
+	// draining is set (atomically) to non-zero once the server has begun draining
+	draining int32
+
+	// shutdownTimeout is the default time to wait for active connections to finish when shutting down
+	shutdownTimeout time.Duration
+)
+
+const (
+	// acceptBackoffMin is the initial delay before retrying after a temporary accept error
+	acceptBackoffMin = time.Millisecond * 5
+
+	// acceptBackoffMax is the maximum delay before retrying after a temporary accept error
+	acceptBackoffMax = time.Second
 )
 
 // ParseFlagsAndSetup parses necessary core server flags, and sets up the core ready for Start() to be called
-func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
+func ParseFlagsAndSetup() {
 	// Setup numerous temporary flag variables, and store the rest
 	// directly in their final operating location. Strings are stored
 	// in `string_constants.go` to allow for later localization
 	sysLog := flag.String(sysLogFlagStr, "stdout", sysLogDescStr)
 	accLog := flag.String(accLogFlagStr, "stdout", accLogDescStr)
+	logFormatStr := flag.String(logFormatFlagStr, "text", logFormatDescStr)
+	logLevelStr := flag.String(logLevelFlagStr, "info", logLevelDescStr)
+	accLogFormat := flag.String(accLogFormatFlagStr, defaultAccessLogFormat, accLogFormatDescStr)
+	logRotateMax := flag.Float64(logRotateSizeFlagStr, 0, logRotateSizeDescStr)
+	flag.DurationVar(&logRotateInterval, logRotateIntervalFlagStr, 0, logRotateIntervalDescStr)
+	flag.IntVar(&logRotateKeep, logRotateKeepFlagStr, 5, logRotateKeepDescStr)
+	flag.IntVar(&logAsyncBufSize, logAsyncBufFlagStr, 1024, logAsyncBufDescStr)
 	flag.StringVar(&Root, rootFlagStr, "/var/gopher", rootDescStr)
 	flag.StringVar(&BindAddr, bindAddrFlagStr, "", bindAddrDescStr)
 	flag.StringVar(&Hostname, hostnameFlagStr, "localhost", hostnameDescStr)
@@ -36,6 +60,10 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 	fwdPort := flag.Uint(fwdPortFlagStr, 0, fwdPortDescStr)
 	flag.DurationVar(&connReadDeadline, readDeadlineFlagStr, time.Duration(time.Second*3), readDeadlineDescStr)
 	flag.DurationVar(&connWriteDeadline, writeDeadlineFlagStr, time.Duration(time.Second*5), writeDeadlineDescStr)
+	flag.DurationVar(&requestTimeout, requestTimeoutFlagStr, time.Duration(time.Second*10), requestTimeoutDescStr)
+	flag.DurationVar(&responseTimeout, responseTimeoutFlagStr, 0, responseTimeoutDescStr)
+	flag.DurationVar(&cgiResponseTimeout, cgiResponseTimeoutFlagStr, time.Duration(time.Second*60), cgiResponseTimeoutDescStr)
+	minRate := flag.Float64(minTransferRateFlagStr, 0, minTransferRateDescStr)
 	cReadBuf := flag.Uint(connReadBufFlagStr, 1024, connReadBufDescStr)
 	cWriteBuf := flag.Uint(connWriteBufFlagStr, 1024, connWriteBufDescStr)
 	cReadMax := flag.Uint(connReadMaxFlagStr, 4096, connReadMaxDescStr)
@@ -47,10 +75,40 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 	remapRequestsList := flag.String(remapRequestsFlagStr, "", remapRequestsDescStr)
 	cgiDir := flag.String(cgiDirFlagStr, "", cgiDirDescStr)
 	flag.DurationVar(&maxCGIRunTime, maxCGITimeFlagStr, time.Duration(time.Second*3), maxCGITimeDescStr)
+	cgiOutputMax := flag.Float64(maxCGIOutputFlagStr, 0, maxCGIOutputDescStr)
+	cgiRlimitCPU := flag.Uint64(cgiRlimitCPUFlagStr, 0, cgiRlimitCPUDescStr)
+	cgiRlimitAS := flag.Float64(cgiRlimitASFlagStr, 0, cgiRlimitASDescStr)
+	cgiRlimitNofile := flag.Uint64(cgiRlimitNofileFlagStr, 0, cgiRlimitNofileDescStr)
+	cgiRlimitNproc := flag.Uint64(cgiRlimitNprocFlagStr, 0, cgiRlimitNprocDescStr)
+	cgiNoNewPrivs := flag.Bool(cgiNoNewPrivsFlagStr, false, cgiNoNewPrivsDescStr)
+	cgiUID := flag.Int(cgiUIDFlagStr, -1, cgiUIDDescStr)
+	cgiGID := flag.Int(cgiGIDFlagStr, -1, cgiGIDDescStr)
+	maxCGIProcs := flag.Uint(maxCGIProcsFlagStr, 0, maxCGIProcsDescStr)
+	flag.IntVar(&maxCGIProcsPerScript, maxCGIProcsPerScriptFlagStr, 0, maxCGIProcsPerScriptDescStr)
+	flag.DurationVar(&cgiKillGrace, cgiKillGraceFlagStr, time.Duration(time.Second*2), cgiKillGraceDescStr)
 	safePath := flag.String(safePathFlagStr, "/bin:/usr/bin", safePathDescStr)
 	httpCompatCGI := flag.Bool(httpCompatCGIFlagStr, false, httpCompatCGIDescStr)
-	httpPrefixBuf := flag.Uint(httpPrefixBufFlagStr, 1024, httpPrefixBufDescStr)
+	cgiHeaderMax := flag.Uint(cgiHeaderMaxFlagStr, 1024, cgiHeaderMaxDescStr)
+	flag.UintVar(cgiHeaderMax, httpPrefixBufFlagStr, 1024, httpPrefixBufDescStr)
 	flag.StringVar(&userDir, userDirFlagStr, "", userDirDescStr)
+	flag.BoolVar(&userCGIEnabled, userCGIFlagStr, false, userCGIDescStr)
+	flag.BoolVar(&userCGISetuid, userCGISetuidFlagStr, false, userCGISetuidDescStr)
+	fastCGIList := flag.String(fastCGIFlagStr, "", fastCGIDescStr)
+	scgiList := flag.String(scgiFlagStr, "", scgiDescStr)
+	cgiCacheList := flag.String(cgiCacheFlagStr, "", cgiCacheDescStr)
+	cgiCacheSize := flag.Uint(cgiCacheSizeFlagStr, 100, cgiCacheSizeDescStr)
+	traceIPList := flag.String(traceIPsFlagStr, "", traceIPsDescStr)
+	traceSelectorList := flag.String(traceSelectorsFlagStr, "", traceSelectorsDescStr)
+	flag.IntVar(&traceBytes, traceBytesFlagStr, 512, traceBytesDescStr)
+	adminSocket := flag.String(adminSocketFlagStr, "", adminSocketDescStr)
+	metricsAddr := flag.String(metricsAddrFlagStr, "", metricsAddrDescStr)
+	throttleConn := flag.Float64(throttleConnFlagStr, 0, throttleConnDescStr)
+	throttleGlobal := flag.Float64(throttleGlobalFlagStr, 0, throttleGlobalDescStr)
+	throttlePathsList := flag.String(throttlePathsFlagStr, "", throttlePathsDescStr)
+	flag.IntVar(&workerCount, workersFlagStr, 0, workersDescStr)
+	flag.IntVar(&acceptQueueSize, acceptQueueFlagStr, 64, acceptQueueDescStr)
+	queueFullPolicy := flag.String(queueFullFlagStr, "reject", queueFullDescStr)
+	flag.DurationVar(&shutdownTimeout, shutdownTimeoutFlagStr, time.Duration(time.Second*30), shutdownTimeoutDescStr)
 	printVersion := flag.Bool(versionFlagStr, false, versionDescStr)
 
 	// Parse flags! (including any set by outer calling function)
@@ -62,13 +120,31 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 		os.Exit(0)
 	}
 
-	// Setup loggers
-	SystemLog = setupLogger(*sysLog)
-	if sysLog == accLog {
-		AccessLog = SystemLog
+	// Parse log format and level
+	format, ok := parseLogFormat(*logFormatStr)
+	if !ok {
+		log.Fatalf(logFormatInvalidStr, *logFormatStr)
+	}
+	level, ok := parseLogLevel(*logLevelStr)
+	if !ok {
+		log.Fatalf(logLevelInvalidStr, *logLevelStr)
+	}
+
+	// Log file rotation size
+	logRotateSize = int64(1048576.0 * *logRotateMax) // gets megabytes value in bytes
+
+	// Setup loggers. Access records are pre-formatted by template, so text
+	// output is written as-is while structured output also gets record fields.
+	// Access records are all logged at info, so the access log level is fixed
+	// rather than following the system log level
+	SystemLog = setupLogger("sys", *sysLog, format, level)
+	if format == logFormatText {
+		AccessLog = setupLogger("acc", *accLog, logFormatRaw, logLevelInfo)
 	} else {
-		AccessLog = setupLogger(*accLog)
+		AccessLog = setupLogger("acc", *accLog, format, logLevelInfo)
 	}
+	accessLogFields = format == logFormatJSON || isStructuredLogOutput(*accLog)
+	accessLogTemplate = compileAccessLogTemplate(*accLogFormat)
 
 	// Check valid values for BindAddr and Hostname
 	if Hostname == "" {
@@ -98,10 +174,17 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 		SystemLog.Fatal(listenerBeginFailStr, BindAddr, Port, err.Error())
 	}
 
+	// Parse accept queue full policy
+	acceptQueueBlock, ok = parseQueueFullPolicy(*queueFullPolicy)
+	if !ok {
+		SystemLog.Fatal(queueFullInvalidStr, *queueFullPolicy)
+	}
+
 	// Host buffer sizes
 	connReadBufSize = int(*cReadBuf)
 	connWriteBufSize = int(*cWriteBuf)
 	connReadMax = int(*cReadMax)
+	minTransferRate = 1024.0 * *minRate // gets kilobytes/sec value in bytes/sec
 	fileReadBufSize = int(*fReadBuf)
 
 	// FileSystemObject (and related) setup
@@ -128,26 +211,74 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 		RemapRequest = remapRequestEnabled
 	}
 
-	// If no CGI dir supplied, set to disabled function. Else, compile and enable
-	if *cgiDir == "" {
+	// Set CGI output and header size limits, and output caching, shared by CGI scripts and backends
+	maxCGIOutput = int64(1048576.0 * *cgiOutputMax) // gets megabytes value in bytes
+	maxCGIHeaderSize = int(*cgiHeaderMax)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == httpPrefixBufFlagStr {
+			SystemLog.Warn(cgiHeaderMaxDeprecatedStr, httpPrefixBufFlagStr, cgiHeaderMaxFlagStr)
+		}
+	})
+	cgiOutputCache = newLRUCacheMap(int(*cgiCacheSize))
+	if *cgiCacheList != "" {
+		cgiCachePaths = compileCGICacheRegex(*cgiCacheList)
+	}
+
+	// If no CGI dir supplied and user CGI disabled, set to disabled function. Else, compile and enable
+	if *cgiDir == "" && !userCGIEnabled {
 		SystemLog.Info(cgiSupportDisabledStr)
 		WithinCGIDir = withinCGIDirDisabled
 	} else {
 		SystemLog.Info(cgiSupportEnabledStr)
-		cgiDirRegex = compileCGIRegex(*cgiDir)
 		cgiEnv = setupInitialCGIEnv(*safePath)
-		WithinCGIDir = withinCGIDirEnabled
+		setupCGISandbox(*cgiRlimitCPU, uint64(1048576.0**cgiRlimitAS), *cgiRlimitNofile, *cgiRlimitNproc, *cgiNoNewPrivs, *cgiUID, *cgiGID)
+
+		// Setup global CGI concurrency limit, if set
+		if *maxCGIProcs > 0 {
+			cgiSlots = make(chan struct{}, *maxCGIProcs)
+		}
+		// Setup server and user CGI dirs
+		if *cgiDir != "" {
+			cgiDirRegex = compileCGIRegex(*cgiDir)
+		}
+		if userCGIEnabled {
+			if userDir == "" {
+				SystemLog.Fatal(userCGINoUserDirStr)
+			}
+
+			// Never run any local user's scripts as root
+			if !userCGISetuid && os.Getuid() == 0 && (cgiCredential == nil || cgiCredential.Uid == 0) {
+				SystemLog.Fatal(userCGIAsRootStr)
+			}
+			SystemLog.Info(userCGIEnabledStr, userCGIDirName, userCGISetuid)
+			WithinCGIDir = withinCGIDirUserEnabled
+		} else {
+			WithinCGIDir = withinCGIDirEnabled
+		}
 
 		// Enable HTTP compatible CGI scripts, or not
 		if *httpCompatCGI {
-			SystemLog.Info(cgiHTTPCompatEnabledStr, httpPrefixBuf)
+			SystemLog.Info(cgiHTTPCompatEnabledStr, maxCGIHeaderSize)
 			ExecuteCGIScript = executeCGIScriptStripHTTP
-			httpPrefixBufSize = int(*httpPrefixBuf)
 		} else {
 			ExecuteCGIScript = executeCGIScriptNoHTTP
 		}
 	}
 
+	// If FastCGI or SCGI backends supplied, compile and enable
+	if *fastCGIList != "" {
+		addBackends(compileBackends(*fastCGIList, serveFastCGI))
+	}
+	if *scgiList != "" {
+		addBackends(compileBackends(*scgiList, serveSCGI))
+	}
+	if len(cgiBackends) > 0 {
+		SystemLog.Info(backendsEnabledStr, len(cgiBackends))
+		if cgiEnv == nil {
+			cgiEnv = setupInitialCGIEnv(*safePath)
+		}
+	}
+
 	// If no user dir supplied, set to disabled function. Else, set user dir and enable
 	if userDir == "" {
 		SystemLog.Info(userDirDisabledStr)
@@ -165,44 +296,206 @@ func ParseFlagsAndSetup(errorMessageFunc func(ErrorCode) string) {
 		}
 	}
 
-	// Set ErrorCode->string function
-	getExtendedErrorMessage = errorMessageFunc
+	// Setup bandwidth throttling, each rate being disabled if 0
+	throttleConnRate = 1024.0 * *throttleConn // gets kilobytes/sec value in bytes/sec
+	if *throttleGlobal > 0 {
+		globalThrottle = newTokenBucket(1024.0 * *throttleGlobal)
+	}
+	if *throttlePathsList != "" {
+		throttlePaths = compileThrottlePathsRegex(*throttlePathsList)
+	}
+	if throttleConnRate > 0 || globalThrottle != nil || len(throttlePaths) > 0 {
+		SystemLog.Info(throttleEnabledStr, *throttleConn, *throttleGlobal)
+	} else {
+		SystemLog.Info(throttleDisabledStr)
+	}
+
+	// If no trace IPs or selectors supplied, leave disabled. Else, compile and enable
+	if *traceIPList == "" && *traceSelectorList == "" {
+		SystemLog.Info(tracingDisabledStr)
+	} else {
+		SystemLog.Info(tracingEnabledStr, traceBytes)
+		traceIPs = compileTraceIPs(*traceIPList)
+		traceSelectors = compileTraceSelectorsRegex(*traceSelectorList)
+		tracingEnabled = true
+	}
+
+	// If no admin socket supplied, leave disabled. Else, setup listener
+	if *adminSocket == "" {
+		SystemLog.Info(adminSocketDisabledStr)
+	} else {
+		adminListener, err = newAdminListener(*adminSocket)
+		if err != nil {
+			SystemLog.Fatal(adminSocketStartFailStr, *adminSocket, err.Error())
+		}
+		setupAdminCommands()
+		SystemLog.Info(adminSocketEnabledStr, *adminSocket)
+	}
+
+	// If no metrics address supplied, leave disabled. Else, setup listener
+	if *metricsAddr == "" {
+		SystemLog.Info(metricsDisabledStr)
+	} else {
+		metricsListener, err = newMetricsListener(*metricsAddr)
+		if err != nil {
+			SystemLog.Fatal(metricsStartFailStr, *metricsAddr, err.Error())
+		}
+		SystemLog.Info(metricsEnabledStr, *metricsAddr)
+	}
 
 	// Setup signal channel
 	sigChannel = make(chan os.Signal)
-	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
+	signal.Notify(sigChannel, syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGUSR1)
 }
 
-// Start begins operation of the server
-func Start(serve func(*Client)) {
+// Start begins operation of the server, serve should return the final Error status of each served request
+func Start(serve func(*Client) Error) {
 	// Start the FileSystemObject cache freshness monitor
 	SystemLog.Info(cacheMonitorStartStr, monitorSleepTime)
 	go FileSystem.StartMonitor()
 
+	// Start the admin socket (if enabled)
+	if adminListener != nil {
+		go serveAdmin()
+	}
+
+	// Start the metrics listener (if enabled)
+	if metricsListener != nil {
+		go serveMetrics()
+	}
+
+	// Start the worker pool (if enabled)
+	if workerCount > 0 {
+		SystemLog.Info(workerPoolEnabledStr, workerCount, acceptQueueSize)
+		startWorkers(serve)
+	} else {
+		SystemLog.Info(workerPoolDisabledStr)
+	}
+
 	// Start the listener
 	SystemLog.Info(listeningOnStr, BindAddr, Port, Hostname, FwdPort)
-	go func() {
-		for {
-			client, err := serverListener.Accept()
-			if err != nil {
-				SystemLog.Error(err.Error())
+	go acceptClients(serve)
+
+	// Listen for OS signals and terminate if necessary
+	listenForOSSignals()
+}
+
+// acceptClients accepts new clients until the listener is closed, serving each in a separate goroutine. Temporary
+// accept errors (e.g. too many open files) are retried with exponential backoff, others trigger a clean shutdown
+func acceptClients(serve func(*Client) Error) {
+	var backoff time.Duration
+	for {
+		client, err := serverListener.Accept()
+		if err != nil {
+			// Listener closed for draining, stop accepting
+			if isDraining() {
+				return
+			}
+
+			// Temporary error, back off and retry
+			if isTemporaryError(err) {
+				if backoff == 0 {
+					backoff = acceptBackoffMin
+				} else if backoff *= 2; backoff > acceptBackoffMax {
+					backoff = acceptBackoffMax
+				}
+				SystemLog.Warn(acceptBackoffStr, backoff, err.Error())
+				time.Sleep(backoff)
+				continue
 			}
 
-			// Serve client then close in separate goroutine
-			go func() {
-				serve(client)
-				client.Conn().Close()
-			}()
+			// Listener is unusable, shutdown cleanly
+			SystemLog.Error(listenerFatalStr, err.Error())
+			go drain(shutdownTimeout, 1)
+			return
+		}
+		backoff = 0
+
+		// Pass client on to be served, unless started draining
+		if !trackClient(client) {
+			client.Conn().Close()
+			return
+		}
+		dispatchClient(client, serve)
+	}
+}
+
+// serveClient serves a client using supplied serve function, recovering from any panic (logging the stack trace
+// and responding with the protocol's panic error response), then closes the connection and records the request
+func serveClient(client *Client, serve func(*Client) Error) {
+	var err Error
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewError(ServePanicErr)
+			client.logger().With("stack", string(debug.Stack())).Error(servePanicStr, r)
+			if response, ok := GetErrorResponse(err); ok {
+				client.Conn().WriteBytes(response)
+			}
 		}
+		finishClient(client, err)
 	}()
 
-	// Listen for OS signals and terminate if necessary
-	listenForOSSignals()
+	// Begin request deadline now serving, not from accept, so time spent queued for a worker isn't counted
+	client.cn.dc.startRequest(requestTimeout)
+	err = serve(client)
+}
+
+// finishClient closes a client's connection, then records the request with supplied final Error status
+func finishClient(client *Client, err Error) {
+	client.Conn().Close()
+	if client.cn.dc.tracer != nil {
+		client.cn.dc.tracer.finish()
+	}
+	recordRequest(client, err)
+	logAccess(client, err)
+	untrackClient(client)
 }
 
-// ListenForOSSignals listens for OS signals and terminates the program if necessary
+// isDraining returns whether the server is currently draining
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) != 0
+}
+
+// Drain stops accepting new connections, then waits up to timeout for active connections to finish before terminating
+func Drain(timeout time.Duration) {
+	drain(timeout, 0)
+}
+
+// drain performs Drain, terminating with supplied exit code
+func drain(timeout time.Duration, exitCode int) {
+	// Only ever drain once
+	if !atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		return
+	}
+
+	// Stop accepting new connections
+	SystemLog.Info(drainingStr, len(ActiveClients()), timeout)
+	serverListener.l.Close()
+
+	// Wait for active clients to finish, or timeout
+	select {
+	case <-waitActiveClients():
+		SystemLog.Info(drainedStr)
+	case <-time.After(timeout):
+		SystemLog.Warn(drainTimeoutStr, len(ActiveClients()))
+	}
+
+	flushLogFiles()
+	os.Exit(exitCode)
+}
+
+// ListenForOSSignals listens for OS signals, reopening log files on SIGUSR1 and otherwise terminating the program
 func listenForOSSignals() {
-	sig := <-sigChannel
-	SystemLog.Info(signalReceivedStr, sig)
-	os.Exit(0)
+	for {
+		sig := <-sigChannel
+		if sig == syscall.SIGUSR1 {
+			reopenLogFiles()
+			SystemLog.Info(logReopenedStr)
+			continue
+		}
+
+		SystemLog.Info(signalReceivedStr, sig)
+		flushLogFiles()
+		os.Exit(0)
+	}
 }
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// recentErrorsMax is the maximum number of recent request errors kept
+const recentErrorsMax = 10
+
+var (
+	// startTime holds the time the server started
+	startTime = time.Now()
+
+	// recentErrors holds a ring buffer of the most recent request errors
+	recentErrors = make([]RecentError, 0, recentErrorsMax)
+
+	// recentErrorsIndex is the next index to overwrite in recentErrors once full
+	recentErrorsIndex int
+
+	// recentErrorsLock protects recentErrors
+	recentErrorsLock sync.Mutex
+)
+
+// RecentError holds details of a recently failed request
+type RecentError struct {
+	Time     time.Time
+	IP       string
+	Selector string
+	Message  string
+}
+
+// Uptime returns the time elapsed since the server started
+func Uptime() time.Duration {
+	return time.Since(startTime)
+}
+
+// RequestCount returns the total number of requests handled
+func RequestCount() int64 {
+	var total int64
+	requestsTotal.Iterate(func(outcome, code string, value int64) {
+		total += value
+	})
+	return total
+}
+
+// RecentErrors returns the most recent request errors, newest first
+func RecentErrors() []RecentError {
+	recentErrorsLock.Lock()
+	defer recentErrorsLock.Unlock()
+
+	ret := make([]RecentError, 0, len(recentErrors))
+	for i := 1; i <= len(recentErrors); i++ {
+		ret = append(ret, recentErrors[(recentErrorsIndex-i+len(recentErrors))%len(recentErrors)])
+	}
+	return ret
+}
+
+// recordRecentError adds a failed request to the recent errors ring buffer
+func recordRecentError(client *Client, err Error) {
+	recentErr := RecentError{time.Now(), client.IP(), client.Selector(), err.Error()}
+
+	recentErrorsLock.Lock()
+	if len(recentErrors) < recentErrorsMax {
+		recentErrors = append(recentErrors, recentErr)
+	} else {
+		recentErrors[recentErrorsIndex] = recentErr
+	}
+	recentErrorsIndex = (recentErrorsIndex + 1) % recentErrorsMax
+	recentErrorsLock.Unlock()
+}
@@ -3,10 +3,31 @@ package core
 // Core flag string constants
 const (
 	sysLogFlagStr = "sys-log"
-	sysLogDescStr = "System log output location ['stdout', 'null', $filename]"
+	sysLogDescStr = "System log output location ['stdout', 'null', 'syslog[:$socket]', 'journald[:$socket]', $filename]"
 
 	accLogFlagStr = "acc-log"
-	accLogDescStr = "Access log output location ['stdout', 'null', $filename]"
+	accLogDescStr = "Access log output location ['stdout', 'null', 'syslog[:$socket]', 'journald[:$socket]', $filename]"
+
+	logFormatFlagStr = "log-format"
+	logFormatDescStr = "Log output format ['text', 'json']"
+
+	logLevelFlagStr = "log-level"
+	logLevelDescStr = "Minimum system log level ['debug', 'info', 'warn', 'error', 'none']"
+
+	accLogFormatFlagStr = "acc-log-format"
+	accLogFormatDescStr = "Access log record template (Go text/template, see AccessRecord fields)"
+
+	logRotateSizeFlagStr = "log-rotate-size"
+	logRotateSizeDescStr = "Rotate log files on reaching size (megabytes, 0 to disable)"
+
+	logRotateIntervalFlagStr = "log-rotate-interval"
+	logRotateIntervalDescStr = "Rotate log files at interval (0 to disable)"
+
+	logRotateKeepFlagStr = "log-rotate-keep"
+	logRotateKeepDescStr = "Number of rotated log files to keep"
+
+	logAsyncBufFlagStr = "log-async-buf"
+	logAsyncBufDescStr = "Log file write queue size, writes are dropped when full (0 for synchronous writes)"
 
 	rootFlagStr = "root"
 	rootDescStr = "Server root directory"
@@ -29,6 +50,18 @@ const (
 	writeDeadlineFlagStr = "write-deadline"
 	writeDeadlineDescStr = "Connection write deadline (timeout)"
 
+	requestTimeoutFlagStr = "request-timeout"
+	requestTimeoutDescStr = "Total time allowed to receive a request (0 to disable)"
+
+	responseTimeoutFlagStr = "response-timeout"
+	responseTimeoutDescStr = "Total time allowed to send a static response, extended by the min transfer rate (0 for none, the write deadline then being the base allowance if a min transfer rate is set)"
+
+	cgiResponseTimeoutFlagStr = "cgi-response-timeout"
+	cgiResponseTimeoutDescStr = "Total time allowed to send a CGI response (0 to disable)"
+
+	minTransferRateFlagStr = "min-transfer-rate"
+	minTransferRateDescStr = "Minimum response transfer rate in KB/s, bounding total response time by response size even if -response-timeout is 0 (0 to disable)"
+
 	connReadBufFlagStr = "conn-read-buf"
 	connReadBufDescStr = "Connection read buffer size (bytes)"
 
@@ -62,22 +95,115 @@ const (
 	maxCGITimeFlagStr = "max-cgi-time"
 	maxCGITimeDescStr = "Max CGI script execution time"
 
+	maxCGIOutputFlagStr = "max-cgi-output"
+	maxCGIOutputDescStr = "Max CGI script output size, scripts exceeding are stopped (megabytes, 0 to disable)"
+
+	cgiRlimitCPUFlagStr = "cgi-rlimit-cpu"
+	cgiRlimitCPUDescStr = "CGI script CPU time limit (seconds, 0 for no limit)"
+
+	cgiRlimitASFlagStr = "cgi-rlimit-as"
+	cgiRlimitASDescStr = "CGI script address space limit (megabytes, 0 for no limit)"
+
+	cgiRlimitNofileFlagStr = "cgi-rlimit-nofile"
+	cgiRlimitNofileDescStr = "CGI script open files limit (0 for no limit)"
+
+	cgiRlimitNprocFlagStr = "cgi-rlimit-nproc"
+	cgiRlimitNprocDescStr = "CGI script user processes limit (0 for no limit)"
+
+	cgiNoNewPrivsFlagStr = "cgi-no-new-privs"
+	cgiNoNewPrivsDescStr = "Prevent CGI scripts gaining privileges (e.g. via setuid binaries), Linux only"
+
+	cgiUIDFlagStr = "cgi-uid"
+	cgiUIDDescStr = "User ID to run CGI scripts as (-1 for server user)"
+
+	cgiGIDFlagStr = "cgi-gid"
+	cgiGIDDescStr = "Group ID to run CGI scripts as (-1 for server group)"
+
+	maxCGIProcsFlagStr = "max-cgi-procs"
+	maxCGIProcsDescStr = "Max concurrently running CGI scripts (0 for no limit)"
+
+	maxCGIProcsPerScriptFlagStr = "max-cgi-procs-per-script"
+	maxCGIProcsPerScriptDescStr = "Max concurrently running instances of each CGI script (0 for no limit)"
+
+	cgiKillGraceFlagStr = "cgi-kill-grace"
+	cgiKillGraceDescStr = "Time allowed for a timed out CGI script to exit after SIGTERM, before SIGKILL"
+
 	safePathFlagStr = "safe-path"
 	safePathDescStr = "CGI environment safe PATH variable"
 
 	httpCompatCGIFlagStr = "http-compat-cgi"
 	httpCompatCGIDescStr = "Enable HTTP compatibility for CGI scripts by stripping headers"
 
+	cgiHeaderMaxFlagStr = "cgi-header-max"
+	cgiHeaderMaxDescStr = "Max size of CGI response headers, larger header sections are rejected with an error (no longer passed through)"
+
 	httpPrefixBufFlagStr = "http-prefix-buf"
-	httpPrefixBufDescStr = "Buffer size used for stripping HTTP headers"
+	httpPrefixBufDescStr = "Deprecated alias of -cgi-header-max"
 
 	userDirFlagStr = "user-dir"
 	userDirDescStr = "User's personal server directory"
 
+	userCGIFlagStr = "user-cgi"
+	userCGIDescStr = "Execute scripts in user's personal server directory 'cgi-bin' as CGI (requires user-dir)"
+
+	userCGISetuidFlagStr = "user-cgi-setuid"
+	userCGISetuidDescStr = "Run user CGI scripts as the owning user (requires running as root)"
+
+	fastCGIFlagStr = "fastcgi"
+	fastCGIDescStr = "New-line separated list of FastCGI backends in format: /prefix -> unix:/path or /prefix -> tcp:host:port"
+
+	cgiCacheFlagStr = "cgi-cache"
+	cgiCacheDescStr = "Cache CGI output by path and query, as new-line separated list of 'regex -> ttl' statements (scripts may also set X-Gophor-TTL or Cache-Control headers)"
+
+	cgiCacheSizeFlagStr = "cgi-cache-size"
+	cgiCacheSizeDescStr = "CGI output cache size, kept separate from the file cache"
+
+	scgiFlagStr = "scgi"
+	scgiDescStr = "New-line separated list of SCGI backends in format: /prefix -> unix:/path or /prefix -> tcp:host:port"
+
+	traceIPsFlagStr = "trace-ips"
+	traceIPsDescStr = "Trace connections from comma separated list of IPs / CIDRs, dumping request line and response bytes"
+
+	traceSelectorsFlagStr = "trace-selectors"
+	traceSelectorsDescStr = "Trace requests for selectors as new-line separated list of regex statements"
+
+	traceBytesFlagStr = "trace-bytes"
+	traceBytesDescStr = "Number of response bytes to dump for traced connections"
+
+	adminSocketFlagStr = "admin-socket"
+	adminSocketDescStr = "Admin control Unix socket path (empty to disable)"
+
+	metricsAddrFlagStr = "metrics-addr"
+	metricsAddrDescStr = "Prometheus metrics listener address, e.g. 127.0.0.1:9070 (empty to disable)"
+
+	throttleConnFlagStr = "throttle-conn"
+	throttleConnDescStr = "Per-connection write rate limit in KB/s (0 to disable)"
+
+	throttleGlobalFlagStr = "throttle-global"
+	throttleGlobalDescStr = "Aggregate write rate limit across all connections in KB/s (0 to disable)"
+
+	throttlePathsFlagStr = "throttle-paths"
+	throttlePathsDescStr = "Per-connection write rate limit overrides as new-line separated list of 'regex -> KB/s' statements"
+
+	workersFlagStr = "workers"
+	workersDescStr = "Number of worker goroutines serving connections (0 for a goroutine per connection)"
+
+	acceptQueueFlagStr = "accept-queue"
+	acceptQueueDescStr = "Number of accepted connections that can wait for a free worker"
+
+	queueFullFlagStr = "queue-full"
+	queueFullDescStr = "Policy when the accept queue is full ['reject', 'block']"
+
+	shutdownTimeoutFlagStr = "shutdown-timeout"
+	shutdownTimeoutDescStr = "Time to wait for active connections to finish when draining or shutting down"
+
 	versionFlagStr = "version"
 	versionDescStr = "Print version string"
 )
 
+// defaultAccessLogFormat is the default access record template, in the style of common log format
+const defaultAccessLogFormat = `{{.IP}} - - [{{.Time}}] "{{.Selector}}{{if .Query}}?{{.Query}}{{end}}" {{.Outcome}} {{.Bytes}} {{.DurationMs}}ms port={{.Port}} cache={{.CacheHit}} cgi={{.CGIExitCode}} id={{.RequestID}}`
+
 // Log string constants
 const (
 	hostnameBindAddrEmptyStr = "At least one of hostname or bind-addr must be non-empty!"
@@ -102,24 +228,105 @@ const (
 	requestRemapRegexCompiledStr    = "Compiled path remap regex: %s"
 	requestRemappedStr              = "Remapped request: %s %s"
 
-	cgiSupportEnabledStr    = "CGI script support enabled"
-	cgiSupportDisabledStr   = "CGI script support disabled"
-	cgiDirOutsideRootStr    = "CGI directory must not be outside server root!"
-	cgiDirStr               = "CGI directory: %s"
-	cgiHTTPCompatEnabledStr = "CGI HTTP compatibility enabled, prefix buffer: %d"
-	cgiExecuteErrStr        = "Exit executing: %s [%d]"
+	cgiSupportEnabledStr          = "CGI script support enabled"
+	cgiSupportDisabledStr         = "CGI script support disabled"
+	cgiDirOutsideRootStr          = "CGI directory must not be outside server root!"
+	cgiDirStr                     = "CGI directory: %s"
+	cgiHTTPCompatEnabledStr       = "CGI HTTP compatibility enabled, max header size: %d"
+	cgiHeaderMaxDeprecatedStr     = "Flag -%s is deprecated, use -%s"
+	cgiExecuteErrStr              = "Exit executing: %s [%d] after %s"
+	cgiExecutedStr                = "Executed: %s in %s"
+	cgiTimeoutStr                 = "Timed out executing: %s after %s, process group stopped"
+	cgiOutputLimitStr             = "Output limit exceeded executing: %s (%d bytes) after %s, process group stopped"
+	cgiStderrStr                  = "CGI stderr: %s"
+	cgiSandboxEnabledStr          = "CGI sandbox enabled, cpu: %ds, as: %d bytes, nofile: %d, nproc: %d, no-new-privs: %t"
+	cgiSandboxUnsupportedStr      = "CGI sandbox not supported on this platform"
+	cgiSandboxExecutableErrStr    = "Failed to find own executable for CGI sandbox: %s"
+	cgiTrampolineErrStr           = "Failed to execute %s in sandbox: %s"
+	cgiTrampolineNotExecutableStr = "CGI sandbox requires own executable %s to be readable and executable by %s"
+	cgiCredentialStr              = "CGI scripts run as uid: %d, gid: %d"
+	cgiSignalErrStr               = "Error sending %s to process group %d: %s"
+	cgiOutputHeldStr              = "Output of process group %d still held open %s after SIGKILL (escaped process?), closing output pipes"
+
+	cgiCachePathInvalidStr      = "Invalid CGI cache statement: %s"
+	cgiCacheRegexCompileFailStr = "Failed compiling CGI cache regex: %s"
+	cgiCacheRegexCompiledStr    = "Compiled CGI cache regex: %s"
+	cgiOutputCachedStr          = "Cached CGI output: %s for %s"
+
+	backendsEnabledStr = "CGI backends enabled: %d"
+	backendInvalidStr  = "Invalid CGI backend statement: %s"
+	backendCompiledStr = "Compiled CGI backend: %s"
+	backendTimeoutStr  = "Timed out on backend: %s after %s"
 
 	userDirEnabledStr         = "User directory support enabled"
 	userDirDisabledStr        = "User directory support disabled"
 	userDirBackTraverseErrStr = "User directory with back-traversal not supported: %s"
 	userDirStr                = "User directory: %s"
+	userCGIEnabledStr         = "User CGI enabled in user directory %s, setuid: %t"
+	userCGINoUserDirStr       = "User CGI requires user directory support enabled!"
+	userCGIAsRootStr          = "User CGI running as root requires either user CGI setuid or a non-root CGI uid!"
+
+	tracingEnabledStr                = "Connection tracing enabled, response bytes: %d"
+	tracingDisabledStr               = "Connection tracing disabled"
+	traceIPStr                       = "Tracing IPs: %s"
+	traceIPInvalidStr                = "Invalid trace IP: %s"
+	traceSelectorRegexCompileFailStr = "Failed compiling trace selector regex: %s"
+	traceSelectorRegexCompiledStr    = "Compiled trace selector regex: %s"
+	traceRequestStr                  = "Trace request: %q"
+	traceResponseStr                 = "Trace response (first %d bytes): %q"
+
+	adminSocketStartFailStr = "Failed to start admin socket on %s (%s)"
+	adminSocketEnabledStr   = "Admin socket listening on: %s"
+	adminSocketDisabledStr  = "Admin socket disabled"
+	adminAcceptErrStr       = "Admin socket accept error: %s"
+	adminCachePurgedStr     = "Admin purged %d cached files matching: %s"
+	adminLogLevelSetStr     = "Admin set %s log level: %s"
+	adminReloadStr          = "Admin requested reload, all cached files marked for reload and log files reopened"
+	adminHelpStr            = "help\n" +
+		"config\n" +
+		"cache stats\n" +
+		"cache keys\n" +
+		"cache purge <path-prefix>\n" +
+		"cache purge-regex <regex>\n" +
+		"conns\n" +
+		"log [<sys|acc> <debug|info|warn|error|none>]\n" +
+		"reload\n" +
+		"drain [timeout]\n" +
+		"quit\n"
+
+	metricsStartFailStr = "Failed to start metrics listener on %s (%s)"
+	metricsEnabledStr   = "Metrics listening on: %s"
+	metricsDisabledStr  = "Metrics disabled"
+	metricsServeErrStr  = "Metrics listener error: %s"
+
+	drainingStr     = "Draining %d active connections with timeout: %s"
+	drainedStr      = "All connections drained. Shutting down..."
+	drainTimeoutStr = "Drain timed out with %d active connections. Shutting down..."
 
 	signalReceivedStr = "Signal received: %v. Shutting down..."
 
-	logOutputErrStr = "Error opening log output %s: %s"
+	throttleEnabledStr              = "Bandwidth throttling enabled, per-connection: %gKB/s, global: %gKB/s"
+	throttleDisabledStr             = "Bandwidth throttling disabled"
+	throttlePathInvalidStr          = "Invalid throttle path statement: %s"
+	throttlePathRegexCompileFailStr = "Failed compiling throttle path regex: %s"
+	throttlePathRegexCompiledStr    = "Compiled throttle path regex: %s"
+
+	workerPoolEnabledStr  = "Worker pool enabled, workers: %d, accept queue: %d"
+	workerPoolDisabledStr = "Worker pool disabled"
+	queueFullInvalidStr   = "Invalid queue full policy: %s"
+
+	acceptBackoffStr = "Temporary accept error, retrying in %s: %s"
+	listenerFatalStr = "Fatal listener error: %s. Shutting down..."
+	servePanicStr    = "Recovered from panic: %v"
 
-	pgidNotFoundErrStr = "Process unfinished, PGID not found!"
-	pgidStopErrStr     = "Error stopping process group %d: %s"
+	accessLogTemplateErrStr = "Access log template error: %s"
+
+	logOutputErrStr     = "Error opening log output %s: %s"
+	logFormatInvalidStr = "Invalid log format: %s"
+	logRotateErrStr     = "Error rotating log output %s: %s"
+	logDroppedStr       = "Log queue full, dropped %d log writes"
+	logReopenedStr      = "Log files reopened"
+	logLevelInvalidStr  = "Invalid log level: %s"
 
 	connWriteErrStr        = "Conn write error"
 	connReadErrStr         = "Conn read error"
@@ -148,4 +355,17 @@ const (
 	cgiStatus501ErrStr     = "CGI status: 501"
 	cgiStatus503ErrStr     = "CGI status: 503"
 	cgiStatusUnknownErrStr = "CGI status: unknown"
+
+	adminListenErrStr         = "Admin socket listen error"
+	invalidAdminCommandErrStr = "Invalid admin command"
+	invalidAdminArgsErrStr    = "Invalid admin command arguments"
+	metricsListenErrStr       = "Metrics listen error"
+	servePanicErrStr          = "Serve panic"
+	serverBusyErrStr          = "Server busy"
+	cgiTimeoutErrStr          = "CGI execution timed out"
+	cgiOutputLimitErrStr      = "CGI output limit exceeded"
+	cgiBusyErrStr             = "CGI concurrency limit reached"
+	cgiBackendErrStr          = "CGI backend error"
+	cgiHeaderSizeErrStr       = "CGI response headers too large"
+	unknownErrStr             = "Unknown error code"
 )
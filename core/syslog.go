@@ -0,0 +1,131 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default local syslog socket path
+const defaultSyslogPath = "/dev/log"
+
+// syslogFacilityDaemon is the syslog facility used for all messages
+const syslogFacilityDaemon = 3
+
+// syslogSDID is the structured data ID used for entry fields (using the documentation reserved enterprise number)
+const syslogSDID = "fields@32473"
+
+// syslogSeverity returns the syslog severity for a log level
+func syslogSeverity(level logLevel) int {
+	switch level {
+	case logLevelDebug:
+		return 7
+	case logLevelInfo:
+		return 6
+	case logLevelWarn:
+		return 4
+	case logLevelError:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// syslogSink implements logSink to write RFC 5424 formatted entries to a local syslog datagram socket
+type syslogSink struct {
+	path     string
+	msgID    string
+	hostname string
+	conn     net.Conn
+	sync.Mutex
+}
+
+// newSyslogSink returns a new syslogSink connected to the socket at path, with supplied message ID, or error
+func newSyslogSink(path, msgID string) (*syslogSink, error) {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &syslogSink{path: path, msgID: msgID, hostname: hostname}
+	s.conn, err = net.Dial("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// writeEntry formats and writes the entry to the syslog socket, redialing once on failure (e.g. syslog daemon restarted)
+func (s *syslogSink) writeEntry(entry *logEntry) {
+	b := s.format(entry)
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.conn.Write(b)
+	if err == nil {
+		return
+	}
+
+	conn, err := net.Dial("unixgram", s.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, logOutputErrStr+"\n", s.path, err.Error())
+		return
+	}
+	s.conn.Close()
+	s.conn = conn
+	s.conn.Write(b)
+}
+
+// format formats an entry as an RFC 5424 syslog message, with fields as structured data
+func (s *syslogSink) format(entry *logEntry) []byte {
+	b := make([]byte, 0, 256)
+	b = append(b, '<')
+	b = strconv.AppendInt(b, int64(syslogFacilityDaemon*8+syslogSeverity(entry.level)), 10)
+	b = append(b, ">1 "...)
+	b = entry.time.UTC().AppendFormat(b, "2006-01-02T15:04:05.000000Z07:00")
+	b = append(b, ' ')
+	b = append(b, s.hostname...)
+	b = append(b, " gophor "...)
+	b = strconv.AppendInt(b, int64(os.Getpid()), 10)
+	b = append(b, ' ')
+	b = append(b, s.msgID...)
+	b = append(b, ' ')
+
+	// Structured data, or nil value if no fields
+	if len(entry.fields) < 2 {
+		b = append(b, '-')
+	} else {
+		b = append(b, "["+syslogSDID...)
+		iterateFields(entry.fields, func(key string, value interface{}) {
+			b = append(b, ' ')
+			b = append(b, syslogParamName(key)...)
+			b = append(b, `="`...)
+			b = append(b, syslogParamValueEscaper.Replace(fmt.Sprint(value))...)
+			b = append(b, '"')
+		})
+		b = append(b, ']')
+	}
+
+	b = append(b, ' ')
+	return append(b, entry.msg...)
+}
+
+// syslogParamValueEscaper escapes the characters not allowed unescaped in structured data param values
+var syslogParamValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogParamName returns key as a valid structured data param name (printable ASCII, no '=', ' ', ']' or '"', max 32 chars)
+func syslogParamName(key string) string {
+	name := make([]byte, 0, len(key))
+	for i := 0; i < len(key) && len(name) < 32; i++ {
+		c := key[i]
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		name = append(name, c)
+	}
+	return string(name)
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenUnixgram returns a unixgram socket standing in for a local log daemon, and its path
+func listenUnixgram(t *testing.T) (*net.UnixConn, string) {
+	dir, err := ioutil.TempDir("", "gophor-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, path
+}
+
+// readDatagram reads a single datagram from conn, failing the test on timeout
+func readDatagram(t *testing.T, conn *net.UnixConn) []byte {
+	b := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b[:n]
+}
+
+func TestSyslogSinkWriteEntry(t *testing.T) {
+	conn, path := listenUnixgram(t)
+	sink, err := newSyslogSink(path, "access")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Date(2020, 5, 17, 13, 4, 5, 123456000, time.UTC)
+	sink.writeEntry(&logEntry{
+		time:   when,
+		level:  logLevelWarn,
+		msg:    "slow client",
+		fields: []interface{}{"client", "127.0.0.1", "bad key=x", `a"b]c\`},
+	})
+	msg := string(readDatagram(t, conn))
+
+	// Header: PRI VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID
+	header := strings.SplitN(msg, " ", 7)
+	if len(header) != 7 {
+		t.Fatalf("malformed message %q", msg)
+	}
+	if header[0] != "<28>1" {
+		t.Errorf("PRI/VERSION = %q, want %q (daemon facility, warning severity)", header[0], "<28>1")
+	}
+	if header[1] != "2020-05-17T13:04:05.123456Z" {
+		t.Errorf("TIMESTAMP = %q", header[1])
+	}
+	if header[2] != sink.hostname || header[2] == "" {
+		t.Errorf("HOSTNAME = %q, want %q", header[2], sink.hostname)
+	}
+	if header[3] != "gophor" {
+		t.Errorf("APP-NAME = %q", header[3])
+	}
+	if header[4] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("PROCID = %q", header[4])
+	}
+	if header[5] != "access" {
+		t.Errorf("MSGID = %q", header[5])
+	}
+
+	// Structured data, with invalid param name characters replaced and value escaped, then message
+	want := `[fields@32473 client="127.0.0.1" bad_key_x="a\"b\]c\\"] slow client`
+	if header[6] != want {
+		t.Errorf("SD MSG = %q, want %q", header[6], want)
+	}
+}
+
+func TestSyslogFormatNoFields(t *testing.T) {
+	sink := &syslogSink{msgID: "system", hostname: "host"}
+	msg := string(sink.format(&logEntry{time: time.Unix(0, 0), level: logLevelError, msg: "failed"}))
+	want := "<27>1 1970-01-01T00:00:00.000000Z host gophor " + strconv.Itoa(os.Getpid()) + " system - failed"
+	if msg != want {
+		t.Errorf("format = %q, want %q", msg, want)
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	for level, want := range map[logLevel]int{
+		logLevelDebug: 7,
+		logLevelInfo:  6,
+		logLevelWarn:  4,
+		logLevelError: 3,
+	} {
+		if got := syslogSeverity(level); got != want {
+			t.Errorf("syslogSeverity(%d) = %d, want %d", level, got, want)
+		}
+	}
+}
+
+func TestSyslogParamName(t *testing.T) {
+	if got := syslogParamName(strings.Repeat("k", 40)); len(got) != 32 {
+		t.Errorf("param name length = %d, want 32", len(got))
+	}
+	if got := syslogParamName(`a=b c]d"e`); got != "a_b_c_d_e" {
+		t.Errorf("param name = %q", got)
+	}
+}
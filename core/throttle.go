@@ -0,0 +1,120 @@
+package core
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunkSize is the max number of bytes written to a throttled conn at once
+const throttleChunkSize = 4096
+
+// throttlePathSeparatorStr specifies the separator string to recognise in throttle path statements
+const throttlePathSeparatorStr = " -> "
+
+var (
+	// throttleConnRate specifies the default per-connection write rate (in bytes/sec), 0 to disable
+	throttleConnRate float64
+
+	// globalThrottle is the token bucket shared by all connections (nil if disabled)
+	globalThrottle *tokenBucket
+
+	// throttlePaths is the global slice of per-path throttle overrides
+	throttlePaths []*ThrottlePath
+)
+
+// ThrottlePath is a structure to hold a path regex to check against, and the per-connection rate (in bytes/sec) to apply
+type ThrottlePath struct {
+	Regex *regexp.Regexp
+	Rate  float64
+}
+
+// compileThrottlePathsRegex turns a string of throttle path statements into a slice of compiled ThrottlePath structures
+func compileThrottlePathsRegex(throttles string) []*ThrottlePath {
+	throttlePaths := make([]*ThrottlePath, 0)
+
+	// Split throttles string by new lines
+	for _, expr := range strings.Split(throttles, "\n") {
+		// Skip empty expressions
+		if len(expr) == 0 {
+			continue
+		}
+
+		// Split into regex and rate
+		split := strings.Split(expr, throttlePathSeparatorStr)
+		if len(split) != 2 {
+			SystemLog.Fatal(throttlePathInvalidStr, expr)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(split[1]), 64)
+		if err != nil || rate < 0 {
+			SystemLog.Fatal(throttlePathInvalidStr, expr)
+		}
+
+		// Compile the regular expression
+		regex, err := regexp.Compile("(?m)" + strings.TrimPrefix(split[0], "/") + "$")
+		if err != nil {
+			SystemLog.Fatal(throttlePathRegexCompileFailStr, expr)
+		}
+
+		// Append ThrottlePath and log
+		throttlePaths = append(throttlePaths, &ThrottlePath{regex, 1024.0 * rate}) // gets kilobytes/sec value in bytes/sec
+		SystemLog.Info(throttlePathRegexCompiledStr, expr)
+	}
+
+	return throttlePaths
+}
+
+// throttleRateFor returns the per-connection write rate (in bytes/sec) for a Path, checking overrides first
+func throttleRateFor(p *Path) float64 {
+	for _, throttle := range throttlePaths {
+		if throttle.Regex.MatchString(p.Relative()) {
+			return throttle.Rate
+		}
+	}
+	return throttleConnRate
+}
+
+// newConnThrottle returns a new per-connection token bucket for supplied rate, or nil if rate is 0
+func newConnThrottle(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return newTokenBucket(rate)
+}
+
+// tokenBucket limits a rate of bytes per second, allowing bursts up to one second's worth. Safe for concurrent use
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// newTokenBucket returns a new full tokenBucket for supplied rate (in bytes/sec)
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait reserves n bytes from the bucket, sleeping for as long as it takes to refill any shortfall
+func (b *tokenBucket) wait(n int) {
+	b.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	shortfall := -b.tokens
+	b.Unlock()
+
+	if shortfall > 0 {
+		time.Sleep(time.Duration(shortfall / b.rate * float64(time.Second)))
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	// requestIDPrefix is the random per-process prefix of all request IDs
+	requestIDPrefix = newRequestIDPrefix()
+
+	// requestIDCounter is the (atomically accessed) counter used for request IDs
+	requestIDCounter uint64
+
+	// tracingEnabled specifies whether any trace IPs or selectors are set
+	tracingEnabled bool
+
+	// traceIPs holds the networks for which connections are traced
+	traceIPs []*net.IPNet
+
+	// traceSelectors holds the selector regexes for which requests are traced
+	traceSelectors []*regexp.Regexp
+
+	// traceBytes specifies the number of response bytes to dump for traced connections
+	traceBytes int
+)
+
+// newRequestIDPrefix returns a new random request ID prefix
+func newRequestIDPrefix() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "00000000"
+	}
+	return hex.EncodeToString(b)
+}
+
+// nextRequestID returns a new unique request ID
+func nextRequestID() string {
+	return requestIDPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&requestIDCounter, 1), 10)
+}
+
+// compileTraceIPs parses a comma separated list of IPs or CIDRs into a slice of networks
+func compileTraceIPs(list string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		// Single IPs are treated as a full-length CIDR
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			SystemLog.Fatal(traceIPInvalidStr, entry)
+		}
+		networks = append(networks, network)
+		SystemLog.Info(traceIPStr, entry)
+	}
+
+	return networks
+}
+
+// compileTraceSelectorsRegex turns a string of trace selectors into a slice of compiled regular expressions
+func compileTraceSelectorsRegex(selectors string) []*regexp.Regexp {
+	regexes := make([]*regexp.Regexp, 0)
+
+	// Split selectors string by new lines
+	for _, expr := range strings.Split(selectors, "\n") {
+		// Skip empty expressions
+		if len(expr) == 0 {
+			continue
+		}
+
+		// Compile the regular expression
+		regex, err := regexp.Compile("(?m)" + expr + "$")
+		if err != nil {
+			SystemLog.Fatal(traceSelectorRegexCompileFailStr, expr)
+		}
+
+		// Append compiled regex and log
+		regexes = append(regexes, regex)
+		SystemLog.Info(traceSelectorRegexCompiledStr, expr)
+	}
+
+	return regexes
+}
+
+// tracer dumps the raw request line and first response bytes of a connection, if it is chosen for tracing
+type tracer struct {
+	client   *Client
+	ipMatch  bool
+	active   bool
+	line     []byte
+	response []byte
+}
+
+// newTracer returns a new tracer for client, checking whether client IP is chosen for tracing
+func newTracer(client *Client) *tracer {
+	t := &tracer{client: client}
+	for _, network := range traceIPs {
+		if network.Contains(*client.ip) {
+			t.ipMatch = true
+			break
+		}
+	}
+	return t
+}
+
+// request records the raw request line, dumping it if the connection is traced by IP
+func (t *tracer) request(line []byte) {
+	t.line = line
+	if t.ipMatch {
+		t.activate()
+	}
+}
+
+// selector checks whether the parsed request selector is chosen for tracing, dumping the raw request line if so
+func (t *tracer) selector(p *Path) {
+	if !t.active && matchesTraceSelector(p.Selector()) {
+		t.activate()
+	}
+}
+
+// activate begins tracing, dumping the raw request line
+func (t *tracer) activate() {
+	t.active = true
+	t.client.logger().Info(traceRequestStr, t.line)
+}
+
+// write captures up to traceBytes of response bytes if active
+func (t *tracer) write(b []byte) {
+	if !t.active || len(t.response) >= traceBytes {
+		return
+	}
+
+	if toAdd := traceBytes - len(t.response); len(b) > toAdd {
+		b = b[:toAdd]
+	}
+	t.response = append(t.response, b...)
+}
+
+// finish dumps the captured response bytes if active
+func (t *tracer) finish() {
+	if t.active {
+		t.client.logger().Info(traceResponseStr, len(t.response), t.response)
+	}
+}
+
+// matchesTraceSelector returns whether a selector matches any of the trace selector regexes
+func matchesTraceSelector(selector string) bool {
+	for _, regex := range traceSelectors {
+		if regex.MatchString(selector) {
+			return true
+		}
+	}
+	return false
+}
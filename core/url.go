@@ -30,16 +30,16 @@ func ParseURLEncodedRequest(received string) (*Request, Error) {
 	}
 
 	// Return new request
-	return &Request{getRequestPath(rawPath), params}, nil
+	return &Request{p: getRequestPath(rawPath), params: params}, nil
 }
 
 // ParseInternalRequest parses an internal request string based on the current directory
 func ParseInternalRequest(p *Path, line string) *Request {
 	rawPath, params := splitBy(line, "?")
 	if path.IsAbs(rawPath) {
-		return &Request{getRequestPath(rawPath), params}
+		return &Request{p: getRequestPath(rawPath), params: params}
 	}
-	return &Request{newSanitizedPath(p.Root(), rawPath), params}
+	return &Request{p: newSanitizedPath(p.Root(), rawPath), params: params}
 }
 
 // getRequestPathUserDirEnabled creates a Path object from raw path, converting ~USER to user subdirectory roots, else at server root
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// userCGIDirName is the name of the CGI scripts dir within a user's personal server directory
+const userCGIDirName = "cgi-bin"
+
+var (
+	// userCGIEnabled specifies whether CGI scripts are executed within user CGI dirs
+	userCGIEnabled bool
+
+	// userCGISetuid specifies whether user CGI scripts are run as the owning user
+	userCGISetuid bool
+)
+
+// withinCGIDirUserEnabled returns whether a Path is within its user's CGI dir if a user path, else within the server CGI dir (if enabled)
+func withinCGIDirUserEnabled(p *Path) bool {
+	if p.Root() != Root {
+		return p.Relative() == userCGIDirName || strings.HasPrefix(p.Relative(), userCGIDirName+"/")
+	}
+	return cgiDirRegex != nil && withinCGIDirEnabled(p)
+}
+
+// cgiCredentialFor returns the credential to run the CGI script at Path as, the owning user's if a user path and
+// setuid enabled, else the server-wide CGI credential (nil to run as server user)
+func cgiCredentialFor(p *Path) (*syscall.Credential, Error) {
+	if !userCGISetuid || p.Root() == Root {
+		return cgiCredential, nil
+	}
+
+	// User roots are always of the form /home/$user/$userDir
+	name, _ := splitBy(strings.TrimPrefix(p.Root(), "/home/"), "/")
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, WrapError(CGIStartErr, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, WrapError(CGIStartErr, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, WrapError(CGIStartErr, err)
+	}
+
+	// Never run user scripts as root
+	if uid == 0 {
+		return nil, NewError(RestrictedPathErr)
+	}
+
+	return newCGICredential(uint32(uid), uint32(gid)), nil
+}
@@ -0,0 +1,86 @@
+package core
+
+import "sync/atomic"
+
+var (
+	// workerCount specifies the number of worker goroutines serving clients, 0 for a goroutine per connection
+	workerCount int
+
+	// acceptQueueSize specifies the number of accepted clients that can wait for a free worker
+	acceptQueueSize int
+
+	// acceptQueueBlock specifies whether to block accepting when the queue is full, rather than reject new clients
+	acceptQueueBlock bool
+
+	// acceptQueue holds accepted clients waiting for a free worker (nil if worker pool disabled)
+	acceptQueue chan *Client
+
+	// busyWorkers is the number of workers currently serving a client, only accessed atomically
+	busyWorkers int64
+
+	// acceptQueueRejectedTotal counts clients rejected due to a full accept queue
+	acceptQueueRejectedTotal = &counter{}
+)
+
+// parseQueueFullPolicy parses a queue full policy string, returning whether to block and success
+func parseQueueFullPolicy(policy string) (bool, bool) {
+	switch policy {
+	case "reject":
+		return false, true
+	case "block":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// startWorkers creates the accept queue and starts the worker pool, each serving clients using supplied serve function
+func startWorkers(serve func(*Client) Error) {
+	acceptQueue = make(chan *Client, acceptQueueSize)
+	for i := 0; i < workerCount; i++ {
+		go worker(serve)
+	}
+}
+
+// worker serves clients from the accept queue until closed
+func worker(serve func(*Client) Error) {
+	for client := range acceptQueue {
+		atomic.AddInt64(&busyWorkers, 1)
+		serveClient(client, serve)
+		atomic.AddInt64(&busyWorkers, -1)
+	}
+}
+
+// dispatchClient passes an accepted client to the worker pool if enabled, else serves in a new goroutine. If the
+// accept queue is full the client is either rejected, or accepting blocks until there is room
+func dispatchClient(client *Client, serve func(*Client) Error) {
+	switch {
+	case acceptQueue == nil:
+		go serveClient(client, serve)
+	case acceptQueueBlock:
+		acceptQueue <- client
+	default:
+		select {
+		case acceptQueue <- client:
+		default:
+			rejectClient(client)
+		}
+	}
+}
+
+// rejectClient responds to a client with the protocol's busy error response, then closes the connection and records the request.
+// Called inline from the accept loop, so a flood of rejected clients can't spawn unbounded goroutines. The response is
+// small enough to fit in a new connection's empty socket send buffer, so writing doesn't block on the client
+func rejectClient(client *Client) {
+	acceptQueueRejectedTotal.Inc()
+	err := NewError(ServerBusyErr)
+	if response, ok := GetErrorResponse(err); ok {
+		client.Conn().WriteBytes(response)
+	}
+	finishClient(client, err)
+}
+
+// acceptQueueDepth returns the number of clients currently waiting in the accept queue
+func acceptQueueDepth() int {
+	return len(acceptQueue)
+}
@@ -0,0 +1,42 @@
+package gopher
+
+import (
+	"gophor/core"
+	"net/url"
+	"strings"
+)
+
+// generateCGIEnv returns gopher specific CGI environment variables for a request
+func generateCGIEnv(client *core.Client, request *core.Request) []string {
+	return []string{
+		"SERVER_PROTOCOL=gopher",
+		"SEARCHREQUEST=" + request.Params(),
+	}
+}
+
+// cgiRedirect responds to a CGI Location redirect with a menu linking to the location. Local selectors and gopher://
+// URLs are linked directly, other URLs via an HTML redirect
+func cgiRedirect(client *core.Client, location string) core.Error {
+	var line []byte
+	u, err := url.Parse(location)
+	switch {
+	case strings.HasPrefix(location, "/"):
+		line = MenuLine(getItemType(location), location, location)
+	case err == nil && u.Scheme == "gopher" && u.Hostname() != "":
+		// Path is /<item type><selector>, defaulting to directory
+		t, selector := typeDirectory, ""
+		if len(u.Path) > 1 {
+			t, selector = ItemType(u.Path[1]), u.Path[2:]
+		}
+		port := u.Port()
+		if port == "" {
+			port = "70"
+		}
+		line = RemoteMenuLine(t, location, selector, u.Hostname(), port)
+	default:
+		line = MenuLine(typeHTML, location, "URL:"+location)
+	}
+
+	client.LogInfo(cgiRedirectFmtStr, location)
+	return WriteMenu(client, InfoLines("Moved to:"), line)
+}
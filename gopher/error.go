@@ -9,84 +9,54 @@ const (
 	SubgophermapSizeErr  core.ErrorCode = 3
 )
 
-// generateErrorMessage returns a message for any gopher specific error codes
-func generateErrorMessage(code core.ErrorCode) string {
-	switch code {
-	case InvalidGophermapErr:
-		return invalidGophermapErrStr
-	case SubgophermapIsDirErr:
-		return subgophermapIsDirErrStr
-	case SubgophermapSizeErr:
-		return subgophermapSizeErrStr
-	default:
-		return unknownErrStr
+// errorMessages maps gopher specific error codes to messages
+var errorMessages = map[core.ErrorCode]string{
+	InvalidGophermapErr:  invalidGophermapErrStr,
+	SubgophermapIsDirErr: subgophermapIsDirErrStr,
+	SubgophermapSizeErr:  subgophermapSizeErrStr,
+}
+
+// errorResponses returns the map of error codes to gopher error responses. Codes not
+// included are not responded to (e.g. write errors, or errors that aren't user facing)
+func errorResponses() map[core.ErrorCode]core.ErrorResponse {
+	return map[core.ErrorCode]core.ErrorResponse{
+		core.ConnReadErr:         errorLine(errorResponse503),
+		core.FileOpenErr:         errorLine(errorResponse404),
+		core.FileStatErr:         errorLine(errorResponse500),
+		core.FileReadErr:         errorLine(errorResponse500),
+		core.FileTypeErr:         errorLine(errorResponse404),
+		core.DirectoryReadErr:    errorLine(errorResponse500),
+		core.RestrictedPathErr:   errorLine(errorResponse403),
+		core.InvalidRequestErr:   errorLine(errorResponse400),
+		core.CGIStartErr:         errorLine(errorResponse500),
+		core.CGIExitCodeErr:      errorLine(errorResponse500),
+		core.CGIStatus400Err:     errorLine(errorResponse400),
+		core.CGIStatus401Err:     errorLine(errorResponse401),
+		core.CGIStatus403Err:     errorLine(errorResponse403),
+		core.CGIStatus404Err:     errorLine(errorResponse404),
+		core.CGIStatus408Err:     errorLine(errorResponse408),
+		core.CGIStatus410Err:     errorLine(errorResponse410),
+		core.CGIStatus500Err:     errorLine(errorResponse500),
+		core.CGIStatus501Err:     errorLine(errorResponse501),
+		core.CGIStatus503Err:     errorLine(errorResponse503),
+		core.CGIStatusUnknownErr: errorLine(errorResponse500),
+		core.ServePanicErr:       errorLine(errorResponse500),
+		core.ServerBusyErr:       errorLine(errorResponse503),
+		core.CGITimeoutErr:       errorLine(errorResponse504),
+		core.CGIOutputLimitErr:   errorLine(errorResponse500),
+		core.CGIBusyErr:          errorLine(errorResponse503),
+		core.CGIBackendErr:       errorLine(errorResponse502),
+		core.CGIHeaderSizeErr:    errorLine(errorResponse500),
+		InvalidGophermapErr:      errorLine(errorResponse500),
+		SubgophermapIsDirErr:     errorLine(errorResponse500),
+		SubgophermapSizeErr:      errorLine(errorResponse500),
 	}
 }
 
-// generateErrorResponse takes an error code and generates an error response byte slice
-func generateErrorResponse(code core.ErrorCode) ([]byte, bool) {
-	switch code {
-	case core.ConnWriteErr:
-		return nil, false // no point responding if we couldn't write
-	case core.ConnReadErr:
-		return buildErrorLine(errorResponse503), true
-	case core.ConnCloseErr:
-		return nil, false // no point responding if we couldn't close
-	case core.ListenerResolveErr:
-		return nil, false // not user facing
-	case core.ListenerBeginErr:
-		return nil, false // not user facing
-	case core.ListenerAcceptErr:
-		return nil, false // not user facing
-	case core.InvalidIPErr:
-		return nil, false // not user facing
-	case core.InvalidPortErr:
-		return nil, false // not user facing
-	case core.FileOpenErr:
-		return buildErrorLine(errorResponse404), true
-	case core.FileStatErr:
-		return buildErrorLine(errorResponse500), true
-	case core.FileReadErr:
-		return buildErrorLine(errorResponse500), true
-	case core.FileTypeErr:
-		return buildErrorLine(errorResponse404), true
-	case core.DirectoryReadErr:
-		return buildErrorLine(errorResponse500), true
-	case core.RestrictedPathErr:
-		return buildErrorLine(errorResponse403), true
-	case core.InvalidRequestErr:
-		return buildErrorLine(errorResponse400), true
-	case core.CGIStartErr:
-		return buildErrorLine(errorResponse500), true
-	case core.CGIExitCodeErr:
-		return buildErrorLine(errorResponse500), true
-	case core.CGIStatus400Err:
-		return buildErrorLine(errorResponse400), true
-	case core.CGIStatus401Err:
-		return buildErrorLine(errorResponse401), true
-	case core.CGIStatus403Err:
-		return buildErrorLine(errorResponse403), true
-	case core.CGIStatus404Err:
-		return buildErrorLine(errorResponse404), true
-	case core.CGIStatus408Err:
-		return buildErrorLine(errorResponse408), true
-	case core.CGIStatus410Err:
-		return buildErrorLine(errorResponse410), true
-	case core.CGIStatus500Err:
-		return buildErrorLine(errorResponse500), true
-	case core.CGIStatus501Err:
-		return buildErrorLine(errorResponse501), true
-	case core.CGIStatus503Err:
-		return buildErrorLine(errorResponse503), true
-	case core.CGIStatusUnknownErr:
-		return buildErrorLine(errorResponse500), true
-	case InvalidGophermapErr:
-		return buildErrorLine(errorResponse500), true
-	case SubgophermapIsDirErr:
-		return buildErrorLine(errorResponse500), true
-	case SubgophermapSizeErr:
-		return buildErrorLine(errorResponse500), true
-	default:
-		return nil, false
+// errorLine returns an ErrorResponse that always generates an error line with supplied selector
+func errorLine(selector string) core.ErrorResponse {
+	response := buildErrorLine(selector)
+	return func(core.Error) []byte {
+		return response
 	}
 }
@@ -2,6 +2,7 @@ package gopher
 
 import (
 	"gophor/core"
+	"net"
 	"os"
 )
 
@@ -12,25 +13,72 @@ type gophermapContents struct {
 
 // WriteToClient renders each cached section of the gophermap, and writes them to the client
 func (gc *gophermapContents) WriteToClient(client *core.Client, path *core.Path) core.Error {
+	// Fast path, a fully static gophermap is pre-rendered (including footer) to a single buffer
+	if len(gc.sections) == 1 {
+		if text, ok := gc.sections[0].(*TextSection); ok {
+			return client.Conn().WriteBytes(text.contents)
+		}
+	}
+
+	// Gather static and pre-renderable sections into buffers to be written together
+	bufs := make(net.Buffers, 0, len(gc.sections))
 	for _, section := range gc.sections {
-		err := section.RenderAndWrite(client)
-		if err != nil {
-			return err
+		switch s := section.(type) {
+		case *TextSection:
+			bufs = append(bufs, s.contents)
+
+		case renderedSection:
+			b, err := s.Render(client)
+			if err != nil {
+				return err
+			}
+			bufs = append(bufs, b)
+
+		default:
+			// Section must write itself, first write out any buffers gathered so far
+			if len(bufs) > 0 {
+				err := client.Conn().WriteBuffers(bufs)
+				if err != nil {
+					return err
+				}
+				bufs = bufs[:0]
+			}
+
+			err := section.RenderAndWrite(client)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
-	// Finally, write the footer (including last-line)
-	return client.Conn().WriteBytes(footer)
+	// Write remaining buffers (the footer always being last of these)
+	return client.Conn().WriteBuffers(bufs)
 }
 
 // Load takes an open FD and loads the gophermap contents into memory as different renderable sections
 func (gc *gophermapContents) Load(fd *os.File, path *core.Path) core.Error {
-	var err core.Error
-	gc.sections, err = readGophermap(fd, path)
-	return err
+	sections, err := readGophermap(fd, path)
+	if err != nil {
+		return err
+	}
+
+	// Coalesce the footer (including last-line) with any trailing static section
+	gc.sections = appendTextSection(sections, footer)
+	return nil
 }
 
 // Clear empties currently cached GophermapContents memory
 func (gc *gophermapContents) Clear() {
 	gc.sections = nil
 }
+
+// Size returns the size in bytes of the cached static sections of GophermapContents
+func (gc *gophermapContents) Size() int {
+	size := 0
+	for _, section := range gc.sections {
+		if text, ok := section.(*TextSection); ok {
+			size += len(text.contents)
+		}
+	}
+	return size
+}
@@ -15,6 +15,22 @@ type gophermapSection interface {
 	RenderAndWrite(*core.Client) core.Error
 }
 
+// renderedSection is implemented by gophermap sections that can be rendered to a byte slice in full before writing
+type renderedSection interface {
+	Render(*core.Client) ([]byte, core.Error)
+}
+
+// appendTextSection appends supplied bytes to sections, coalescing with the last section if this is also a TextSection
+func appendTextSection(sections []gophermapSection, b []byte) []gophermapSection {
+	if len(sections) > 0 {
+		if last, ok := sections[len(sections)-1].(*TextSection); ok {
+			last.contents = append(last.contents, b...)
+			return sections
+		}
+	}
+	return append(sections, &TextSection{b})
+}
+
 // readGophermap reads a FD and Path as gophermap sections
 func readGophermap(fd *os.File, p *core.Path) ([]gophermapSection, core.Error) {
 	// Create return slice
@@ -38,13 +54,13 @@ func readGophermap(fd *os.File, p *core.Path) ([]gophermapSection, core.Error) {
 			switch lineType {
 			case typeInfoNotStated:
 				// Append TypeInfo to beginning of line
-				sections = append(sections, &TextSection{buildInfoLine(line)})
+				sections = appendTextSection(sections, buildInfoLine(line))
 				return true
 
 			case typeTitle:
 				// Reformat title line to send as info line with appropriate selector
 				if !titleAlready {
-					sections = append(sections, &TextSection{buildLine(typeInfo, line[1:], "TITLE", nullHost, nullPort)})
+					sections = appendTextSection(sections, buildLine(typeInfo, line[1:], "TITLE", nullHost, nullPort))
 					titleAlready = true
 					return true
 				}
@@ -101,12 +117,12 @@ func readGophermap(fd *os.File, p *core.Path) ([]gophermapSection, core.Error) {
 
 				// Handle regular file
 				if !isGophermap(request.Path()) {
-					sections = append(sections, &FileSection{})
+					sections = append(sections, &FileSection{request.Path()})
 					return true
 				}
 
 				// Handle gophermap
-				sections = append(sections, &SubgophermapSection{})
+				sections = append(sections, &SubgophermapSection{request.Path()})
 				return true
 
 			case typeEnd:
@@ -121,7 +137,7 @@ func readGophermap(fd *os.File, p *core.Path) ([]gophermapSection, core.Error) {
 
 			default:
 				// Default is appending to sections slice as TextSection
-				sections = append(sections, &TextSection{[]byte(line + "\r\n")})
+				sections = appendTextSection(sections, []byte(line+"\r\n"))
 				return true
 			}
 		},
@@ -153,12 +169,13 @@ type DirectorySection struct {
 	path   *core.Path
 }
 
-// RenderAndWrite scans and renders a list of the contents of a directory (skipping hidden or restricted files)
-func (s *DirectorySection) RenderAndWrite(client *core.Client) core.Error {
+// Render scans and renders a list of the contents of a directory (skipping hidden or restricted files)
+func (s *DirectorySection) Render(client *core.Client) ([]byte, core.Error) {
 	fd, err := core.FileSystem.OpenFile(s.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer fd.Close()
 
 	// Slice to write
 	dirContents := make([]byte, 0)
@@ -169,11 +186,19 @@ func (s *DirectorySection) RenderAndWrite(client *core.Client) core.Error {
 		dirContents = appendFileListing(dirContents, file, p)
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Write dirContents to client
-	return client.Conn().WriteBytes(dirContents)
+	return dirContents, nil
+}
+
+// RenderAndWrite renders the directory listing and writes it to the client
+func (s *DirectorySection) RenderAndWrite(client *core.Client) core.Error {
+	b, err := s.Render(client)
+	if err != nil {
+		return err
+	}
+	return client.Conn().WriteBytes(b)
 }
 
 // FileSection is an implementation that holds a file path, and writes the file contents to client
@@ -181,21 +206,25 @@ type FileSection struct {
 	path *core.Path
 }
 
-// RenderAndWrite simply opens, reads and writes the file contents to the client
-func (s *FileSection) RenderAndWrite(client *core.Client) core.Error {
+// Render simply opens and reads the file contents
+func (s *FileSection) Render(client *core.Client) ([]byte, core.Error) {
 	// Open FD for the file
 	fd, err := core.FileSystem.OpenFile(s.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer fd.Close()
 
 	// Read the file contents into memory
-	b, err := core.FileSystem.ReadFile(fd)
+	return core.FileSystem.ReadFile(fd)
+}
+
+// RenderAndWrite simply opens, reads and writes the file contents to the client
+func (s *FileSection) RenderAndWrite(client *core.Client) core.Error {
+	b, err := s.Render(client)
 	if err != nil {
 		return err
 	}
-
-	// Write the file contents to the client
 	return client.Conn().WriteBytes(b)
 }
 
@@ -212,6 +241,8 @@ func (s *SubgophermapSection) RenderAndWrite(client *core.Client) core.Error {
 		return err
 	}
 
+	defer fd.Close()
+
 	// Read gophermap into sections
 	sections, err := readGophermap(fd, s.path)
 	if err != nil {
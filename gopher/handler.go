@@ -0,0 +1,49 @@
+package gopher
+
+import (
+	"gophor/core"
+	"strings"
+)
+
+// Exported item types for building menu lines in native handlers
+const (
+	TypeFile      = typeFile
+	TypeDirectory = typeDirectory
+	TypeError     = typeError
+	TypeSearch    = typeSearch
+	TypeBin       = typeBin
+	TypeImage     = typeImage
+	TypeHTML      = typeHTML
+	TypeInfo      = typeInfo
+)
+
+// MenuLine builds a gopher menu line of item type t, linking to selector on this server
+func MenuLine(t ItemType, name, selector string) []byte {
+	return buildLine(t, name, selector, core.Hostname, core.FwdPort)
+}
+
+// RemoteMenuLine builds a gopher menu line of item type t, linking to selector on another host
+func RemoteMenuLine(t ItemType, name, selector, host, port string) []byte {
+	return buildLine(t, name, selector, host, port)
+}
+
+// InfoLines builds gopher info lines from text, one per new line
+func InfoLines(text string) []byte {
+	ret := make([]byte, 0)
+	for _, line := range strings.Split(text, "\n") {
+		ret = append(ret, buildInfoLine(line)...)
+	}
+	return ret
+}
+
+// MenuFooter returns the configured footer and gophermap last-line, to end menus written by native handlers
+func MenuFooter() []byte {
+	return footer
+}
+
+// WriteMenu writes supplied menu lines followed by the menu footer to a client
+func WriteMenu(client *core.Client, lines ...[]byte) core.Error {
+	bufs := make([][]byte, 0, len(lines)+1)
+	bufs = append(bufs, lines...)
+	return client.Conn().WriteBuffers(append(bufs, footer))
+}
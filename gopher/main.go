@@ -13,7 +13,13 @@ func setup() {
 	admin := flag.String(adminFlagStr, "", adminDescStr)
 	desc := flag.String(descFlagStr, "", descDescStr)
 	geo := flag.String(geoFlagStr, "", geoDescStr)
-	core.ParseFlagsAndSetup(generateErrorMessage)
+	serverStatus := flag.String(serverStatusFlagStr, "", serverStatusDescStr)
+	serverStatusAllow := flag.String(serverStatusAllowFlagStr, "127.0.0.0/8,::1/128", serverStatusAllowDescStr)
+	core.RegisterErrorMessages(errorMessages)
+	core.RegisterErrorResponses(errorResponses())
+	core.RegisterCGIEnv(generateCGIEnv)
+	core.RegisterCGIRedirect(cgiRedirect)
+	core.ParseFlagsAndSetup()
 
 	// Setup gopher specific global variables
 	subgophermapSizeMax = int64(1048576.0 * *subgopherSizeMax) // convert float to megabytes
@@ -28,6 +34,15 @@ func setup() {
 	// Add generated files to cache
 	core.FileSystem.AddGeneratedFile(core.NewPath(core.Root, "caps.txt"), capsTxt)
 	core.FileSystem.AddGeneratedFile(core.NewPath(core.Root, "robots.txt"), robotsTxt)
+
+	// If server status selector supplied, setup allowed networks and add dynamic file to cache
+	if *serverStatus == "" {
+		core.SystemLog.Info(serverStatusDisabledStr)
+	} else {
+		serverStatusAllowed = compileServerStatusAllowList(*serverStatusAllow)
+		core.FileSystem.AddDynamicFile(core.NewPath(core.Root, *serverStatus), generateServerStatus)
+		core.SystemLog.Info(serverStatusEnabledStr, *serverStatus, *serverStatusAllow)
+	}
 }
 
 // Run does as says :)
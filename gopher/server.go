@@ -6,14 +6,14 @@ import (
 	"strings"
 )
 
-// serve is the global gopher server's serve function
-func serve(client *core.Client) {
+// serve is the global gopher server's serve function, returning the final Error status
+func serve(client *core.Client) core.Error {
 	// Receive line from client
 	received, err := client.Conn().ReadLine()
 	if err != nil {
-		client.LogError(clientReadFailStr)
+		client.LogError(err, clientReadFailStr)
 		handleError(client, err)
-		return
+		return err
 	}
 
 	// Convert to string
@@ -25,15 +25,15 @@ func serve(client *core.Client) {
 	if len(line) < lenBefore {
 		client.Conn().WriteBytes(generateHTMLRedirect(line))
 		client.LogInfo(clientRedirectFmtStr, line)
-		return
+		return nil
 	}
 
 	// Parse new request
 	request, err := core.ParseURLEncodedRequest(line)
 	if err != nil {
-		client.LogError(clientRequestParseFailStr)
+		client.LogError(err, clientRequestParseFailStr)
 		handleError(client, err)
-		return
+		return err
 	}
 
 	// Handle the request!
@@ -85,20 +85,18 @@ func serve(client *core.Client) {
 
 	// Final error handling
 	if err != nil {
+		client.LogError(err, clientServeFailStr, request.Path().Absolute())
 		handleError(client, err)
-		client.LogError(clientServeFailStr, request.Path().Absolute())
-	} else {
-		client.LogInfo(clientServedStr, request.Path().Absolute())
 	}
+	return err
 }
 
-// handleError determines whether to send an error response to the client, and logs to system
+// handleError determines whether to send an error response to the client
 func handleError(client *core.Client, err core.Error) {
-	response, ok := generateErrorResponse(err.Code())
+	response, ok := core.GetErrorResponse(err)
 	if ok {
 		client.Conn().WriteBytes(response)
 	}
-	core.SystemLog.Error(err.Error())
 }
 
 // newFileContents returns a new FileContents object
@@ -0,0 +1,94 @@
+package gopher
+
+import (
+	"fmt"
+	"gophor/core"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	// serverStatusAllowed holds the networks permitted to view the server status page
+	serverStatusAllowed []*net.IPNet
+)
+
+// compileServerStatusAllowList parses a comma or new-line separated list of CIDRs into a slice of networks
+func compileServerStatusAllowList(list string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+
+	for _, cidr := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			core.SystemLog.Fatal(serverStatusCIDRInvalidStr, cidr)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// serverStatusAllowedIP returns whether supplied IP string is within the server status allowed networks
+func serverStatusAllowedIP(ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range serverStatusAllowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateServerStatus generates the server status gophermap for the requesting client, if allowed
+func generateServerStatus(client *core.Client, p *core.Path) ([]byte, core.Error) {
+	if !serverStatusAllowedIP(client.IP()) {
+		return nil, core.NewError(core.RestrictedPathErr)
+	}
+
+	uptime := core.Uptime()
+	requests := core.RequestCount()
+	stats := core.FileSystem.Stats()
+	clients := core.ActiveClients()
+
+	// Calculate cache hit ratio
+	hitRatio := 0.0
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		hitRatio = float64(stats.Hits) / float64(lookups) * 100
+	}
+
+	status := make([]byte, 0)
+	status = append(status, buildLine(typeInfo, "[ "+core.Hostname+" server status ]", "TITLE", nullHost, nullPort)...)
+	status = append(status, buildInfoLine("")...)
+	status = append(status, buildInfoLine("Server version: Gophor "+core.Version)...)
+	status = append(status, buildInfoLine("Current time: "+time.Now().Format(time.RFC1123))...)
+	status = append(status, buildInfoLine("Uptime: "+uptime.Round(time.Second).String())...)
+	status = append(status, buildInfoLine(fmt.Sprintf("Total requests: %d (%.3f req/s)", requests, float64(requests)/uptime.Seconds()))...)
+	status = append(status, buildInfoLine("")...)
+	status = append(status, buildInfoLine(fmt.Sprintf("Cache entries: %d (%d bytes)", stats.Entries, stats.Bytes))...)
+	status = append(status, buildInfoLine(fmt.Sprintf("Cache hits: %d, misses: %d (%.1f%% hit ratio)", stats.Hits, stats.Misses, hitRatio))...)
+	status = append(status, buildInfoLine(fmt.Sprintf("Cache evictions: %d, reloads: %d", stats.Evictions, stats.Reloads))...)
+	status = append(status, buildInfoLine("")...)
+
+	status = append(status, buildInfoLine(fmt.Sprintf("Active connections: %d", len(clients)))...)
+	for _, c := range clients {
+		status = append(status, buildInfoLine(fmt.Sprintf("  %s %s %s", c.IP(), c.Duration().Round(time.Millisecond), c.Selector()))...)
+	}
+	status = append(status, buildInfoLine("")...)
+
+	recent := core.RecentErrors()
+	status = append(status, buildInfoLine(fmt.Sprintf("Recent errors: %d", len(recent)))...)
+	for _, e := range recent {
+		status = append(status, buildInfoLine(fmt.Sprintf("  %s %s %s: %s", e.Time.Format(time.RFC3339), e.IP, e.Selector, e.Message))...)
+	}
+
+	return append(status, footer...), nil
+}
@@ -10,7 +10,9 @@ const (
 	errorResponse410 = "410 Gone"
 	errorResponse500 = "500 Internal Server Error"
 	errorResponse501 = "501 Not Implemented"
+	errorResponse502 = "502 Bad Gateway"
 	errorResponse503 = "503 Service Unavailable"
+	errorResponse504 = "504 Gateway Time-out"
 )
 
 // Gopher flag string constants
@@ -32,18 +34,27 @@ const (
 
 	geoFlagStr = "geolocation"
 	geoDescStr = "Generated policy file server geolocation"
+
+	serverStatusFlagStr = "server-status"
+	serverStatusDescStr = "Server status page selector, e.g. /server-status (empty to disable)"
+
+	serverStatusAllowFlagStr = "server-status-allow"
+	serverStatusAllowDescStr = "Comma separated list of CIDRs allowed to view the server status page"
 )
 
 // Log string constants
 const (
 	clientReadFailStr         = "Failed to read"
 	clientRedirectFmtStr      = "Redirecting to: %s"
+	cgiRedirectFmtStr         = "CGI redirect to: %s"
 	clientRequestParseFailStr = "Failed to parse request"
 	clientServeFailStr        = "Failed to serve: %s"
-	clientServedStr           = "Served: %s"
+
+	serverStatusEnabledStr     = "Server status page enabled at: %s (allowed: %s)"
+	serverStatusDisabledStr    = "Server status page disabled"
+	serverStatusCIDRInvalidStr = "Invalid server status allowed CIDR: %s"
 
 	invalidGophermapErrStr  = "Invalid gophermap"
 	subgophermapIsDirErrStr = "Subgophermap path is dir"
 	subgophermapSizeErrStr  = "Subgophermap size too large"
-	unknownErrStr           = "Unknown error code"
 )
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"gophor/core"
 	"gophor/gopher"
 )
 
 func main() {
+	// Re-executed as the CGI sandbox trampoline, never returns
+	core.RunCGITrampoline()
+
 	gopher.Run()
 }